@@ -1,178 +1,508 @@
 package nmfmt
 
 import (
-	"regexp"
-	"strings"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
 	"sync"
 )
 
-/*
-var placeholderRE = regexp.MustCompile(`{([^:{}}]+)(?::([^{}}]+))?}`)
-var extract = func(format string, index []int) (string, string) {
-	name := strings.TrimSpace(format[index[2]:index[3]])
-	verb := ""
-	if index[4] != -1 {
-		verb = strings.TrimSpace(format[index[4]:index[5]])
-	}
-	return name, verb
-}
-*/
-
-var placeholderRE = regexp.MustCompile(`(?:\$(=?\w+)(?::(\+|#|\w))?)|(?:\${(=?[^:{}}]+)(?::([^{}}]+))?})`)
-var extract = func(format string, index []int) (string, string, bool) {
-	var eq bool
-
-	if index[2] != -1 {
-		name := strings.TrimSpace(format[index[2]:index[3]])
-		if strings.HasPrefix(name, "=") {
-			name = name[1:]
-			eq = true
-		}
-		verb := ""
-		if index[4] != -1 {
-			verb = strings.TrimSpace(format[index[4]:index[5]])
-		}
-		return name, verb, eq
-	}
-
-	name := strings.TrimSpace(format[index[6]:index[7]])
-	if strings.HasPrefix(name, "=") {
-		name = name[1:]
-		eq = true
-	}
-	verb := ""
-	if index[8] != -1 {
-		verb = strings.TrimSpace(format[index[8]:index[9]])
-	}
-	return name, verb, eq
-}
-
 type cache struct {
-	m     sync.Mutex
-	nodes map[string]cachenode
+	m     sync.RWMutex
+	nodes map[string]*cachenode
 
 	cacheResetLimit int
 	cachemisses     int
+
+	filters map[string]filter
 }
 
-func newCache(refreshRate int) *cache {
+func newCache(refreshRate int, filters map[string]filter) *cache {
 	return &cache{
-		nodes:           make(map[string]cachenode),
+		nodes:           make(map[string]*cachenode),
 		cacheResetLimit: refreshRate,
+		filters:         filters,
 	}
 }
 
-func (c *cache) get(format string) (cn cachenode) {
-	c.m.Lock()
+func (c *cache) get(format string) *cachenode {
+	c.m.RLock()
+	cn, found := c.nodes[format]
+	c.m.RUnlock()
+	if found {
+		return cn
+	}
 
-	var found bool
+	c.m.Lock()
 	if cn, found = c.nodes[format]; found {
 		c.m.Unlock()
-		return
+		return cn
 	}
 
-	cn = newCacheNode(format)
+	cn, _ = newCacheNode(format, c.filters)
 	c.cachemisses++
 	if c.cachemisses >= c.cacheResetLimit {
 		c.cachemisses = 0
-		c.nodes = make(map[string]cachenode)
+		c.nodes = make(map[string]*cachenode)
 	}
 	c.nodes[format] = cn
 	c.m.Unlock()
-	return
+	return cn
 }
 
 type cachenode struct {
-	format    string
-	argsOrder []string // a slice of names of args
+	source    string   // the original format
+	ops       []op     // rendered in order
+	argsOrder []string // a slice of unique names of args
+	simple    bool     // only of literals and simple ops
 }
 
+// ExtractNames returns the names of args referred in format.
+//
+// For a path like $user.Name or $items[0], only the name (user, items) is returned.
+// Names in a repeating section are not returned, since they are looked up in each element first.
+// A name after `|` without `$` is taken as a filter, registered or not.
 func ExtractNames(format string) map[string]struct{} {
-	indices := placeholderRE.FindAllStringSubmatchIndex(format, -1)
-	if len(indices) == 0 {
-		return nil
+	return extract(format, false)
+}
+
+// ExtractPaths is like ExtractNames, but returns paths as written like user.Name or items[0].
+func ExtractPaths(format string) map[string]struct{} {
+	return extract(format, true)
+}
+
+func extract(format string, paths bool) map[string]struct{} {
+	// filters are unknown here
+	toks, _ := parse(format, nil)
+
+	var names map[string]struct{}
+	add := func(root string, path []segment) {
+		if names == nil {
+			names = make(map[string]struct{})
+		}
+		if paths {
+			root = joinPath(root, path)
+		}
+		names[root] = struct{}{}
 	}
 
-	names := make(map[string]struct{})
+	var loops []string // open repeating sections
+	for _, t := range toks {
+		if t.ph == nil {
+			continue
+		}
 
-	for i := 0; i < len(indices); i++ {
-		index := indices[i]
+		switch {
+		case t.ph.sect == '/' && len(loops) != 0 && loops[len(loops)-1] == t.ph.name():
+			loops = loops[:len(loops)-1]
+			continue
+		case len(loops) != 0:
+			if t.ph.sect == '#' {
+				loops = append(loops, t.ph.name())
+			}
+			continue
+		case t.ph.sect == '#':
+			loops = append(loops, t.ph.name())
+		}
 
-		name, _, _ := extract(format, index)
-		names[name] = struct{}{}
+		add(t.ph.root, t.ph.path)
+		for _, alt := range t.ph.alts {
+			if !alt.isLit {
+				add(alt.root, alt.path)
+			}
+		}
+		if t.ph.spec.widthArg != "" {
+			add(t.ph.spec.widthArg, nil)
+		}
+		if t.ph.spec.precArg != "" {
+			add(t.ph.spec.precArg, nil)
+		}
 	}
 
 	return names
 }
 
-func newCacheNode(format string) cachenode {
-	format = strings.ReplaceAll(format, "%", "%%")
+// newCacheNode compiles format into a tree of ops.
+//
+// Malformed placeholders and sections are left as they are, and reported as a *SyntaxError.
+func newCacheNode(format string, filters map[string]filter) (*cachenode, error) {
+	toks, err := parse(format, filters)
+
+	cn := &cachenode{source: format}
 
-	indices := placeholderRE.FindAllStringSubmatchIndex(format, -1)
-	if len(indices) == 0 {
-		return cachenode{format: format}
+	// open sections
+	type frame struct {
+		o    op
+		name string
+		pos  int
 	}
+	var stack []frame
 
-	var cformat string
-	var caorder []string
+	add := func(o op) {
+		if len(stack) == 0 {
+			cn.ops = appendOp(cn.ops, o)
+		} else {
+			top := &stack[len(stack)-1]
+			top.o.body = appendOp(top.o.body, o)
+		}
+	}
 
-	last := 0
-	for i := 0; i < len(indices); i++ {
-		cformat += format[last:indices[i][0]]
+	for _, t := range toks {
+		if t.ph == nil {
+			add(op{lit: t.lit, arg: -1})
+			continue
+		}
 
-		index := indices[i]
+		ph := t.ph
 
-		name, verb, eq := extract(format, index)
-		if eq {
-			cformat += name + "="
+		switch ph.sect {
+		case '?', '^', '#':
+			o := op{raw: ph.raw, sect: ph.sect, sep: ph.sep, arg: cn.argIndex(ph.root), path: ph.path}
+			stack = append(stack, frame{o: o, name: ph.name(), pos: ph.pos})
+			continue
+
+		case '/':
+			if len(stack) == 0 || stack[len(stack)-1].name != ph.name() {
+				if err == nil {
+					err = &SyntaxError{Format: format, Offset: ph.pos, Msg: "unmatched " + ph.raw}
+				}
+				add(op{lit: ph.raw, arg: -1})
+				continue
+			}
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			add(top.o)
+			continue
 		}
-		if verb == "" { // not found
-			cformat += "%v"
-		} else {
-			cformat += "%" + verb
+
+		spec := ph.spec
+		if spec.verb == 0 { // not found
+			spec.verb = 'v'
+		}
+
+		o := newValueOp(cn.argIndex(ph.root), ph.path, spec)
+		if spec.widthArg != "" {
+			o.widthArg = cn.argIndex(spec.widthArg)
+		}
+		if spec.precArg != "" {
+			o.precArg = cn.argIndex(spec.precArg)
+		}
+		o.raw = ph.raw
+		if ph.eq {
+			o.eq = ph.name() + "="
+		}
+		for _, alt := range ph.alts {
+			if !alt.isLit {
+				alt.arg = cn.argIndex(alt.root)
+			}
+			o.alts = append(o.alts, alt)
+		}
+		o.filters = ph.filters
+		o.simple = len(o.path) == 0 && len(o.alts) == 0 && len(o.filters) == 0 &&
+			!o.wrap && !o.group && o.widthArg == -1 && o.precArg == -1
+		add(o)
+	}
+
+	// unclosed sections are literals
+	for len(stack) != 0 {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if err == nil {
+			err = &SyntaxError{Format: format, Offset: top.pos, Msg: "unclosed section " + top.o.raw}
+		}
+		add(op{lit: top.o.raw, arg: -1})
+		for _, o := range top.o.body {
+			add(o)
+		}
+	}
+
+	cn.simple = true
+	for i := range cn.ops {
+		if cn.ops[i].arg != -1 && !cn.ops[i].simple {
+			cn.simple = false
+			break
 		}
-		caorder = append(caorder, name)
+	}
+
+	return cn, err
+}
 
-		last = index[1]
+// appendOp appends o to ops, joining literals.
+func appendOp(ops []op, o op) []op {
+	if o.arg == -1 && len(ops) != 0 && ops[len(ops)-1].arg == -1 {
+		ops[len(ops)-1].lit += o.lit
+		return ops
 	}
-	cformat += format[last:]
+	return append(ops, o)
+}
 
-	return cachenode{
-		format:    cformat,
-		argsOrder: caorder,
+// argIndex returns the index of name in argsOrder, adding it if not found.
+func (c *cachenode) argIndex(name string) int {
+	for i, n := range c.argsOrder {
+		if n == name {
+			return i
+		}
 	}
+	c.argsOrder = append(c.argsOrder, name)
+	return len(c.argsOrder) - 1
 }
 
-func findSliceArg(a []any, name string) any {
-	for i := 0; i < len(a)-1; i += 2 {
-		if a[i].(string) == name {
+// findSliceArg returns the value of name in a, which is of key/value pairs and sources (see lookupSource).
+//
+// The first arg having name wins.
+func findSliceArg(a []any, name string, naming NamingStrategy) any {
+	// key/value pairs only
+	i := 0
+	for ; i+1 < len(a); i += 2 {
+		k, ok := a[i].(string)
+		if !ok {
+			break
+		}
+		if k == name {
 			return a[i+1]
 		}
 	}
-	return nil
+	if i < len(a) {
+		return findMixedArg(a[i:], name, naming)
+	}
+	return absentArg{}
 }
 
-func (c cachenode) construct(a []any, alloc func() *[]any) (*[]any, error) {
-	if len(c.argsOrder) == 0 {
-		return nil, nil
+// findMixedArg is findSliceArg for a with sources.
+func findMixedArg(a []any, name string, naming NamingStrategy) any {
+	for i := 0; i < len(a); i++ {
+		if k, ok := a[i].(string); ok {
+			if i+1 < len(a) && k == name {
+				return a[i+1]
+			}
+			i++
+			continue
+		}
+
+		if v, found, ok := lookupSource(a[i], name, naming); ok {
+			if found {
+				return v
+			}
+			continue
+		}
+		i++ // a key of another type, and its value
 	}
+	return absentArg{}
+}
 
-	//aa := make([]any, 0, len(c.aorder))
-	aa := alloc()
-	*aa = (*aa)[:0]
+// findAttr returns the value of the attr name in attrs.
+//
+// Attrs of a group without a key are looked up as if they were in attrs.
+func findAttr(attrs []slog.Attr, name string) (any, bool) {
+	for _, attr := range attrs {
+		if attr.Key == name {
+			return attrValue(attr.Value), true
+		}
+		if attr.Key == "" && attr.Value.Kind() == slog.KindGroup {
+			if v, found := findAttr(attr.Value.Group(), name); found {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// attrValue returns the value of an attr, with a group as M.
+func attrValue(v slog.Value) any {
+	v = v.Resolve()
+	if v.Kind() != slog.KindGroup {
+		return v.Any()
+	}
+
+	attrs := v.Group()
+	m := make(M, len(attrs))
+	addAttrs(m, attrs)
+	return m
+}
+
+func addAttrs(m M, attrs []slog.Attr) {
+	for _, attr := range attrs {
+		if attr.Key == "" && attr.Value.Kind() == slog.KindGroup {
+			addAttrs(m, attr.Value.Group())
+			continue
+		}
+		m[attr.Key] = attrValue(attr.Value)
+	}
+}
+
+// singleStruct returns a structArg of v given as the only arg, if v is a struct or a pointer to a struct.
+//
+// slog.Attr is not, and is looked up as an attr.
+func singleStruct(v any, naming NamingStrategy) (structArg, bool) {
+	switch v.(type) {
+	case string, slog.Attr:
+		return structArg{}, false
+	}
+	return newStructArg(v, naming)
+}
+
+// absentArg is the value of a name not found in args.
+type absentArg struct{}
+
+// appendMapValues appends the values of names in m to vals, or absentArg{} for those not found.
+func appendMapValues[Map ~map[K]V, K ~string, V any](vals []any, names []string, m Map) []any {
+	for _, name := range names {
+		if v, found := m[K(name)]; found {
+			vals = append(vals, v)
+		} else {
+			vals = append(vals, absentArg{})
+		}
+	}
+	return vals
+}
+
+// appendLookups appends the values of names in l to vals, or absentArg{} for those not found.
+func appendLookups[L argLookuper](vals []any, names []string, l L) []any {
+	for _, name := range names {
+		v, found := l.lookup(name)
+		if !found {
+			v = absentArg{}
+		}
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+// argLookuper is an arg of values by names, other than key/value pairs.
+type argLookuper interface {
+	lookup(name string) (any, bool)
+	eachName(fn func(name string))
+}
+
+// lookuperArg is an arg of a Lookuper, whose names are unknown and never reported as unused,
+// unless it is made by Sources.
+type lookuperArg struct {
+	l Lookuper
+}
+
+func (l lookuperArg) lookup(name string) (any, bool) {
+	return l.l.Lookup(name)
+}
+
+func (l lookuperArg) eachName(fn func(name string)) {
+	if n, ok := l.l.(interface{ eachName(fn func(name string)) }); ok {
+		n.eachName(fn)
+	}
+}
+
+// mapArg is an arg of a map with string keys, of any type.
+type mapArg struct {
+	v reflect.Value
+}
+
+// newMapArg returns a mapArg of v, if v is a map with keys of a string kind.
+func newMapArg(v any) (mapArg, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return mapArg{}, false
+	}
+	return mapArg{v: rv}, true
+}
+
+func (m mapArg) lookup(name string) (any, bool) {
+	mv := m.v.MapIndex(reflect.ValueOf(name).Convert(m.v.Type().Key()))
+	if !mv.IsValid() {
+		return nil, false
+	}
+	return mv.Interface(), true
+}
+
+func (m mapArg) eachName(fn func(name string)) {
+	iter := m.v.MapRange()
+	for iter.Next() {
+		fn(iter.Key().String())
+	}
+}
+
+// construct appends the values of c.argsOrder to vals.
+//
+// absentArg{} is appended for a name not found.
+// Fields of a single struct arg are named by naming.
+func (c *cachenode) construct(a []any, vals []any, naming NamingStrategy) ([]any, error) {
+	if len(c.argsOrder) == 0 {
+		return vals, nil
+	}
 
 	if len(a) == 1 {
-		if m, ok := a[0].(M); ok {
-			for i := 0; i < len(c.argsOrder); i++ {
-				*aa = append(*aa, m[c.argsOrder[i]])
-			}
-			return aa, nil
+		switch m := a[0].(type) {
+		case M:
+			return appendMapValues(vals, c.argsOrder, m), nil
+		case map[string]any:
+			return appendMapValues(vals, c.argsOrder, m), nil
+		case map[string]string:
+			return appendMapValues(vals, c.argsOrder, m), nil
+		case map[string]int:
+			return appendMapValues(vals, c.argsOrder, m), nil
+		}
+		if l, ok := a[0].(Lookuper); ok {
+			return appendLookups(vals, c.argsOrder, lookuperArg{l}), nil
+		}
+		if m, ok := newMapArg(a[0]); ok {
+			return appendLookups(vals, c.argsOrder, m), nil
+		}
+		if s, ok := singleStruct(a[0], naming); ok {
+			return appendLookups(vals, c.argsOrder, &s), nil
 		}
 	}
 
 	for i := 0; i < len(c.argsOrder); i++ {
-		*aa = append(*aa, findSliceArg(a, c.argsOrder[i]))
+		vals = append(vals, findSliceArg(a, c.argsOrder[i], naming))
+	}
+
+	return vals, nil
+}
+
+// fields returns a map of c.argsOrder and vals, except for absent ones.
+func (c *cachenode) fields(vals []any) M {
+	m := make(M, len(c.argsOrder))
+	for i, name := range c.argsOrder {
+		if _, absent := vals[i].(absentArg); !absent {
+			m[name] = vals[i]
+		}
 	}
+	return m
+}
 
-	return aa, nil
+// unused returns sorted names in a that are not in c.argsOrder.
+func (c *cachenode) unused(a []any, naming NamingStrategy) []string {
+	var names []string
+
+	add := func(name string) {
+		if !slices.Contains(c.argsOrder, name) && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	for i := 0; i < len(a); i++ {
+		if eachSourceName(a[i], naming, add) {
+			continue
+		}
+		if i+1 < len(a) {
+			if name, ok := a[i].(string); ok {
+				add(name)
+			} else {
+				// never referred
+				add(fmt.Sprintf("!BADKEY(%T=%v)", a[i], a[i]))
+			}
+		}
+		i++
+	}
+	slices.Sort(names)
+	return names
+}
+
+// eachAttrKey calls fn with the keys of attrs, including those of groups without keys.
+func eachAttrKey(attrs []slog.Attr, fn func(key string)) {
+	for _, attr := range attrs {
+		if attr.Key == "" && attr.Value.Kind() == slog.KindGroup {
+			eachAttrKey(attr.Value.Group(), fn)
+			continue
+		}
+		fn(attr.Key)
+	}
 }
@@ -0,0 +1,113 @@
+package nmfmt
+
+import (
+	"strings"
+	"sync"
+)
+
+// Catalog maps message IDs to formats by languages.
+//
+// Languages are tags like ja-JP, and compared case-insensitively (ja_JP is also ja-JP).
+// A Catalog is safe for concurrent use.
+type Catalog struct {
+	m        sync.RWMutex
+	formats  map[string]map[string]string // language -> id -> format
+	fallback string
+}
+
+// NewCatalog returns an empty Catalog that falls back to the language fallback.
+func NewCatalog(fallback string) *Catalog {
+	return &Catalog{
+		formats:  make(map[string]map[string]string),
+		fallback: normalizeLang(fallback),
+	}
+}
+
+// Set sets the format of the message id in lang.
+func (c *Catalog) Set(lang, id, format string) {
+	c.Add(lang, map[string]string{id: format})
+}
+
+// Add sets the formats of messages (id -> format) in lang.
+func (c *Catalog) Add(lang string, formats map[string]string) {
+	lang = normalizeLang(lang)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	m := c.formats[lang]
+	if m == nil {
+		m = make(map[string]string, len(formats))
+		c.formats[lang] = m
+	}
+	for id, format := range formats {
+		m[id] = format
+	}
+}
+
+// Lookup returns the format of the message id in lang.
+//
+// If not found, less specific languages and then the fallback are looked up: ja-JP, ja, en.
+func (c *Catalog) Lookup(lang, id string) (string, bool) {
+	lang = normalizeLang(lang)
+
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	for lang != "" {
+		if format, found := c.formats[lang][id]; found {
+			return format, true
+		}
+
+		i := strings.LastIndexByte(lang, '-')
+		if i == -1 {
+			break
+		}
+		lang = lang[:i]
+	}
+
+	format, found := c.formats[c.fallback][id]
+	return format, found
+}
+
+func normalizeLang(lang string) string {
+	return strings.ToLower(strings.ReplaceAll(lang, "_", "-"))
+}
+
+// Messages sets the catalog used by T.
+func Messages(c *Catalog) OptionFunc {
+	return func(f *formatterOptions) {
+		f.catalog = c
+	}
+}
+
+// Localize returns a Formatter that looks up messages in lang by T.
+//
+// The returned Formatter shares the options and the cache with f.
+// Numbers are also printed in lang unless [Locale] is set.
+func (f *Formatter) Localize(lang string) *Formatter {
+	opts := f.opts
+	opts.lang = lang
+	opts.setNumbers()
+
+	return &Formatter{
+		cache:     f.cache,
+		opts:      opts,
+		statePool: sync.Pool{New: newRenderState},
+	}
+}
+
+// T formats the message id in the language of f, with args a.
+//
+// If the message is not found in the catalog, id is returned as it is.
+func (f *Formatter) T(id string, a ...any) string {
+	if f.opts.catalog == nil {
+		return id
+	}
+
+	format, found := f.opts.catalog.Lookup(f.opts.lang, id)
+	if !found {
+		return id
+	}
+	return f.sprintf(f.cache.get(format), a)
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"go/ast"
 	"go/format"
 	"go/parser"
 	"go/token"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/shu-go/gli/v2"
 	"github.com/shu-go/nmfmt"
@@ -59,7 +63,17 @@ func (c globalCmd) Run(args []string) error {
 			continue
 		}
 
-		names := nmfmt.ExtractNames(arg0.Value)
+		format, err := strconv.Unquote(arg0.Value)
+		if err != nil {
+			continue
+		}
+		names := nmfmt.ExtractNames(format)
+		for name := range names {
+			if _, ok := goIdent(name); !ok {
+				fmt.Fprintf(os.Stderr, "%v: $%s cannot be a Go value, skipped\n", fset.Position(arg0.Pos()), name)
+				delete(names, name)
+			}
+		}
 
 		if len(names) > 0 && len(n.Args) != 2 {
 			n.Args = append(n.Args, &ast.CompositeLit{
@@ -77,6 +91,9 @@ func (c globalCmd) Run(args []string) error {
 			})
 			changed = true
 		}
+		if len(n.Args) != 2 {
+			continue
+		}
 		arg1, ok1 := n.Args[1].(*ast.CompositeLit)
 		if !ok1 {
 			continue
@@ -108,12 +125,18 @@ func (c globalCmd) Run(args []string) error {
 				Key   string
 				Value ast.Node
 			}{
-				Key:   key.Value[1 : len(key.Value)-1],
+				Key:   unquote(key.Value),
 				Value: kv.Value,
 			})
 		}
 
+		sorted := make([]string, 0, len(names))
 		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+
+		for _, name := range sorted {
 			found := false
 			for _, kv := range kvs {
 				if kv.Key == name {
@@ -123,13 +146,14 @@ func (c globalCmd) Run(args []string) error {
 			}
 
 			if !found {
+				ident, _ := goIdent(name)
 				arg1.Elts = append(arg1.Elts, &ast.KeyValueExpr{
 					Key: &ast.BasicLit{
 						Kind:  token.STRING,
-						Value: `"` + name + `"`,
+						Value: strconv.Quote(name),
 					},
 					Value: &ast.Ident{
-						Name: name,
+						Name: ident,
 					},
 				})
 				changed = true
@@ -161,6 +185,41 @@ func (c globalCmd) Run(args []string) error {
 	return nil
 }
 
+// goIdent maps a placeholder name like ${user-id} or ${1st} to a Go identifier (user_id, _1st).
+//
+// Characters not allowed in an identifier become `_`, and `_` is prepended to a keyword or a name starting with a digit.
+// It returns false for a name that cannot be a value: `_`, or a name of a loop like @index.
+func goIdent(name string) (string, bool) {
+	if name == "_" || strings.HasPrefix(name, "@") {
+		return "", false
+	}
+	if token.IsIdentifier(name) {
+		return name, true
+	}
+
+	var sb strings.Builder
+	for _, r := range name {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			sb.WriteRune(r)
+		} else {
+			sb.WriteByte('_')
+		}
+	}
+
+	ident := sb.String()
+	if !token.IsIdentifier(ident) || ident == "_" {
+		ident = "_" + ident
+	}
+	return ident, true
+}
+
+func unquote(lit string) string {
+	if s, err := strconv.Unquote(lit); err == nil {
+		return s
+	}
+	return lit
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 func find[T any](root ast.Node, test func(n T) bool) T {
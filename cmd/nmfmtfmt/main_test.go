@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shu-go/gotwant"
+)
+
+func TestGoIdent(t *testing.T) {
+	cases := []struct {
+		name  string
+		ident string
+		ok    bool
+	}{
+		{name: "name", ident: "name", ok: true},
+		{name: "名前", ident: "名前", ok: true},
+		{name: "größe", ident: "größe", ok: true},
+		{name: "user-id", ident: "user_id", ok: true},
+		{name: "1st", ident: "_1st", ok: true},
+		{name: "type", ident: "_type", ok: true},
+		{name: "-", ident: "__", ok: true},
+		{name: "_"},
+		{name: "@index"},
+	}
+	for _, c := range cases {
+		ident, ok := goIdent(c.name)
+		gotwant.Test(t, ident, c.ident, gotwant.Desc(c.name))
+		gotwant.Test(t, ok, c.ok, gotwant.Desc(c.name))
+	}
+}
+
+func TestRun(t *testing.T) {
+	src := `package a
+
+import "github.com/shu-go/nmfmt"
+
+func f() {
+	nmfmt.Printf("$名前 is ${user-id} ${1st} $_ $@index\n")
+	nmfmt.Printf("$name $age", nmfmt.M{"name": "x"})
+	nmfmt.Printf("${#items}$@index${/items}")
+	nmfmt.Printf("no names")
+}
+`
+	path := filepath.Join(t.TempDir(), "a.go")
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	gotwant.TestError(t, globalCmd{}.Run([]string{path}), nil)
+
+	b, err := os.ReadFile(path)
+	gotwant.TestError(t, err, nil)
+	got := string(b)
+	for _, want := range []string{
+		`nmfmt.M{"1st": _1st, "user-id": user_id, "名前": 名前}`,
+		`nmfmt.M{"name": "x", "age": age}`,
+		`nmfmt.M{"items": items}`,
+		`nmfmt.Printf("no names")`,
+	} {
+		gotwant.Test(t, strings.Contains(got, want), true, gotwant.Desc(want))
+	}
+}
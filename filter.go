@@ -0,0 +1,249 @@
+package nmfmt
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// filter is a function applied to a value in a placeholder like ${name|upper}.
+type filter struct {
+	fn      func(v any, args ...string) (any, error)
+	minArgs int
+	maxArgs int // -1 for unlimited
+}
+
+// filterCall is a filter with its args in a placeholder.
+type filterCall struct {
+	name string
+	args []string
+	fn   func(v any, args ...string) (any, error)
+}
+
+// FilterError describes a filter that failed.
+type FilterError struct {
+	Name   string // the name of the placeholder
+	Filter string
+	Err    error
+}
+
+func (e *FilterError) Error() string {
+	return "nmfmt: " + e.Name + "|" + e.Filter + ": " + e.Err.Error()
+}
+
+func (e *FilterError) Unwrap() error {
+	return e.Err
+}
+
+var builtinFilters = map[string]filter{
+	"upper":  {fn: filterUpper},
+	"lower":  {fn: filterLower},
+	"trim":   {fn: filterTrim, maxArgs: 1},
+	"trunc":  {fn: filterTrunc, minArgs: 1, maxArgs: 2},
+	"join":   {fn: filterJoin, maxArgs: 1},
+	"hex":    {fn: filterHex},
+	"base64": {fn: filterBase64},
+	"len":    {fn: filterLen},
+	"plural": {fn: filterPlural, minArgs: 2, maxArgs: 2},
+	"select": {fn: filterSelect, minArgs: 1, maxArgs: -1},
+
+	"percent": {fn: filterPercent, maxArgs: 1},
+	"decimal": {fn: filterDecimal, maxArgs: 1},
+}
+
+// mergeFilters returns builtinFilters with user filters.
+func mergeFilters(user map[string]func(v any, args ...string) (any, error)) map[string]filter {
+	if len(user) == 0 {
+		return builtinFilters
+	}
+
+	filters := make(map[string]filter, len(builtinFilters)+len(user))
+	for name, f := range builtinFilters {
+		filters[name] = f
+	}
+	for name, fn := range user {
+		filters[name] = filter{fn: fn, maxArgs: -1}
+	}
+	return filters
+}
+
+// toString converts v into a string for string filters.
+func toString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	case error:
+		return v.Error()
+	}
+	return fmt.Sprint(v)
+}
+
+// filterUpper converts into upper case.
+func filterUpper(v any, args ...string) (any, error) {
+	return strings.ToUpper(toString(v)), nil
+}
+
+// filterLower converts into lower case.
+func filterLower(v any, args ...string) (any, error) {
+	return strings.ToLower(toString(v)), nil
+}
+
+// filterTrim removes leading and trailing spaces, or characters in args[0].
+func filterTrim(v any, args ...string) (any, error) {
+	if len(args) == 0 {
+		return strings.TrimSpace(toString(v)), nil
+	}
+	return strings.Trim(toString(v), args[0]), nil
+}
+
+// filterTrunc cuts into args[0] runes, followed by args[1] if cut.
+func filterTrunc(v any, args ...string) (any, error) {
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid length %q", args[0])
+	}
+
+	s := toString(v)
+	if utf8.RuneCountInString(s) <= n {
+		return s, nil
+	}
+
+	i := 0
+	for pos := range s {
+		if i == n {
+			s = s[:pos]
+			break
+		}
+		i++
+	}
+	if len(args) == 2 {
+		s += args[1]
+	}
+	return s, nil
+}
+
+// filterJoin joins elements of a slice or an array with args[0] (defaults to ", ").
+func filterJoin(v any, args ...string) (any, error) {
+	sep := ", "
+	if len(args) != 0 {
+		sep = args[0]
+	}
+
+	switch v := v.(type) {
+	case []string:
+		return strings.Join(v, sep), nil
+	case string:
+		return v, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return toString(v), nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < rv.Len(); i++ {
+		if i != 0 {
+			sb.WriteString(sep)
+		}
+		sb.WriteString(toString(rv.Index(i).Interface()))
+	}
+	return sb.String(), nil
+}
+
+// filterHex encodes a string or bytes into hexadecimal, or formats an integer in base 16.
+func filterHex(v any, args ...string) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return hex.EncodeToString([]byte(v)), nil
+	case []byte:
+		return hex.EncodeToString(v), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 16), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 16), nil
+	}
+	return hex.EncodeToString([]byte(toString(v))), nil
+}
+
+// filterBase64 encodes a string or bytes with the standard encoding.
+func filterBase64(v any, args ...string) (any, error) {
+	if b, ok := v.([]byte); ok {
+		return base64.StdEncoding.EncodeToString(b), nil
+	}
+	return base64.StdEncoding.EncodeToString([]byte(toString(v))), nil
+}
+
+// filterLen returns the length of a slice, an array, a map or the number of runes of a string.
+func filterLen(v any, args ...string) (any, error) {
+	if s, ok := v.(string); ok {
+		return utf8.RuneCountInString(s), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return rv.Len(), nil
+	}
+	return utf8.RuneCountInString(toString(v)), nil
+}
+
+// filterPlural chooses args[0] if the number is 1 (or -1), otherwise args[1].
+func filterPlural(v any, args ...string) (any, error) {
+	var one bool
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		one = rv.Int() == 1 || rv.Int() == -1
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		one = rv.Uint() == 1
+	case reflect.Float32, reflect.Float64:
+		one = rv.Float() == 1 || rv.Float() == -1
+	default:
+		return nil, fmt.Errorf("not a number: %T", v)
+	}
+
+	if one {
+		return args[0], nil
+	}
+	return args[1], nil
+}
+
+// filterSelect chooses the text of the case that matches the value in args like `admin=Administrator`.
+//
+// `other` matches any value.
+func filterSelect(v any, args ...string) (any, error) {
+	s := toString(v)
+
+	other, hasOther := "", false
+	for _, arg := range args {
+		key, text, found := strings.Cut(arg, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid case %q", arg)
+		}
+		if key == s {
+			return text, nil
+		}
+		if key == "other" && !hasOther {
+			other, hasOther = text, true
+		}
+	}
+
+	if !hasOther {
+		return nil, fmt.Errorf("no case for %q", s)
+	}
+	return other, nil
+}
@@ -3,11 +3,23 @@ package nmfmt
 import (
 	"fmt"
 	"io"
+	"maps"
+	"os"
+	"strings"
 	"sync"
 )
 
 type formatterOptions struct {
 	cacheResetLimit int
+	missingKey      MissingKeyPolicy
+	strictArgs      bool
+	onUnusedArgs    func(format string, names []string)
+	filters         map[string]func(v any, args ...string) (any, error)
+	catalog         *Catalog
+	lang            string
+	locale          string
+	numbers         numberLocale // of locale, or of lang
+	naming          NamingStrategy
 }
 
 type OptionFunc func(*formatterOptions)
@@ -19,9 +31,87 @@ func CacheResetLimit(misses int) OptionFunc {
 	}
 }
 
+// MissingKeyPolicy decides how a placeholder without its value is printed.
+type MissingKeyPolicy int
+
+const (
+	// MissingNil prints <nil>, and a fmt style marker like %!v(NILPTR=user.Address) for a broken path. (default)
+	MissingNil MissingKeyPolicy = iota
+	// MissingEmpty prints nothing.
+	MissingEmpty
+	// MissingKeep prints the placeholder as written.
+	MissingKeep
+	// MissingError fails with a *MissingKeyError.
+	MissingError
+)
+
+// MissingKey sets how a placeholder without its value is printed.
+func MissingKey(p MissingKeyPolicy) OptionFunc {
+	return func(f *formatterOptions) {
+		f.missingKey = p
+	}
+}
+
+// MissingKeyError is returned if the policy is MissingError.
+type MissingKeyError struct {
+	Names []string // names (with paths) of unresolved placeholders, in order of appearance
+}
+
+func (e *MissingKeyError) Error() string {
+	return "nmfmt: missing keys: " + strings.Join(e.Names, ", ")
+}
+
+// StrictArgs fails a call with an *UnusedArgsError if some of args are not referred in the format.
+func StrictArgs() OptionFunc {
+	return func(f *formatterOptions) {
+		f.strictArgs = true
+	}
+}
+
+// OnUnusedArgs calls fn with names of args not referred in the format.
+//
+// Unlike StrictArgs, the call does not fail.
+func OnUnusedArgs(fn func(format string, names []string)) OptionFunc {
+	return func(f *formatterOptions) {
+		f.onUnusedArgs = fn
+	}
+}
+
+// Filters adds filters used like ${amount|money:JPY}.
+//
+// A filter receives the value and args following `:`, and returns a new value.
+// A filter with the same name as a built-in one replaces it.
+func Filters(filters map[string]func(v any, args ...string) (any, error)) OptionFunc {
+	return func(f *formatterOptions) {
+		if f.filters == nil {
+			f.filters = make(map[string]func(v any, args ...string) (any, error))
+		}
+		for name, fn := range filters {
+			f.filters[name] = fn
+		}
+	}
+}
+
+// UnusedArgsError is returned if StrictArgs is set.
+type UnusedArgsError struct {
+	Format string
+	Names  []string // sorted; a key not a string is named like !BADKEY(int=1)
+}
+
+func (e *UnusedArgsError) Error() string {
+	return "nmfmt: unused args: " + strings.Join(e.Names, ", ")
+}
+
 type Formatter struct {
-	cache       *cache
-	argsBufPool sync.Pool
+	cache     *cache
+	opts      formatterOptions
+	statePool sync.Pool
+}
+
+// renderState holds buffers used in a call.
+type renderState struct {
+	vals []any
+	buf  []byte
 }
 
 func New(opts ...OptionFunc) Formatter {
@@ -31,112 +121,199 @@ func New(opts ...OptionFunc) Formatter {
 	for _, o := range opts {
 		o(&fo)
 	}
+	fo.setNumbers()
 
 	return Formatter{
-		cache: newCache(fo.cacheResetLimit),
-		argsBufPool: sync.Pool{
-			New: func() any {
-				return &[]any{}
-			},
-		},
+		cache:     newCache(fo.cacheResetLimit, mergeFilters(fo.filters)),
+		opts:      fo,
+		statePool: sync.Pool{New: newRenderState},
 	}
 }
 
+func newRenderState() any {
+	return &renderState{buf: make([]byte, 0, 64)}
+}
+
 func (f *Formatter) Printf(format string, a ...any) (int, error) {
 	if len(a) == 0 {
 		return fmt.Printf(format)
 	}
 
-	cn := f.cache.get(format)
+	return f.fprintf(os.Stdout, f.cache.get(format), a)
+}
 
-	aa, err := cn.construct(a, func() *[]any {
-		return f.argsBufPool.Get().(*[]any)
-	})
-	if err != nil {
-		return 0, err
+func (f *Formatter) Fprintf(w io.Writer, format string, a ...any) (int, error) {
+	if len(a) == 0 {
+		return fmt.Fprintf(w, format)
 	}
 
-	var n int
-	if aa == nil {
-		n, err = fmt.Printf(cn.format)
-	} else {
-		n, err = fmt.Printf(cn.format, (*aa)...)
-		f.argsBufPool.Put(aa)
+	return f.fprintf(w, f.cache.get(format), a)
+}
+
+func (f *Formatter) Sprintf(format string, a ...any) string {
+	if len(a) == 0 {
+		return fmt.Sprintf(format)
 	}
 
-	return n, err
+	return f.sprintf(f.cache.get(format), a)
 }
 
-func (f *Formatter) Fprintf(w io.Writer, format string, a ...any) (int, error) {
+func (f *Formatter) Errorf(format string, a ...any) error {
 	if len(a) == 0 {
-		return fmt.Fprintf(w, format)
+		return fmt.Errorf(format)
+	}
+
+	return f.errorf(f.cache.get(format), a)
+}
+
+// Appendf formats according to format, appends the result to b and returns it.
+//
+// Unlike Errorf, it makes no error value of the result. On an error of formatting, b is returned as it is.
+func (f *Formatter) Appendf(b []byte, format string, a ...any) ([]byte, error) {
+	return f.appendf(b, f.cache.get(format), a)
+}
+
+func (f *Formatter) getState() *renderState {
+	return f.statePool.Get().(*renderState)
+}
+
+func (f *Formatter) putState(st *renderState) {
+	// large buffers are not worth keeping
+	if cap(st.buf) > 64*1024 {
+		return
 	}
+	clear(st.vals)
+	st.vals = st.vals[:0]
+	st.buf = st.buf[:0]
+	f.statePool.Put(st)
+}
 
-	cn := f.cache.get(format)
+// render appends cn with args a to b.
+func (f *Formatter) render(b []byte, cn *cachenode, a []any, st *renderState) ([]byte, error) {
+	if err := f.checkUnused(cn, a); err != nil {
+		return b, err
+	}
 
-	aa, err := cn.construct(a, func() *[]any {
-		return f.argsBufPool.Get().(*[]any)
-	})
+	var err error
+	st.vals, err = cn.construct(a, st.vals[:0], f.opts.naming)
 	if err != nil {
-		return 0, err
+		return b, err
 	}
 
-	var n int
-	if aa == nil {
-		n, err = fmt.Fprintf(w, cn.format)
-	} else {
-		n, err = fmt.Fprintf(w, cn.format, (*aa)...)
-		f.argsBufPool.Put(aa)
+	b, _, err = cn.render(b, st.vals, &f.opts)
+	return b, err
+}
+
+// checkUnused reports args not referred in cn, according to the options.
+func (f *Formatter) checkUnused(cn *cachenode, a []any) error {
+	if !f.opts.strictArgs && f.opts.onUnusedArgs == nil {
+		return nil
 	}
 
-	return n, err
-}
+	names := cn.unused(a, f.opts.naming)
+	if len(names) == 0 {
+		return nil
+	}
 
-func (f *Formatter) Sprintf(format string, a ...any) string {
-	if len(a) == 0 {
-		return fmt.Sprintf(format)
+	if f.opts.onUnusedArgs != nil {
+		f.opts.onUnusedArgs(cn.source, names)
+	}
+	if f.opts.strictArgs {
+		return &UnusedArgsError{Format: cn.source, Names: names}
 	}
+	return nil
+}
 
-	cn := f.cache.get(format)
+func (f *Formatter) fprintf(w io.Writer, cn *cachenode, a []any) (int, error) {
+	st := f.getState()
+	defer f.putState(st)
 
-	aa, err := cn.construct(a, func() *[]any {
-		return f.argsBufPool.Get().(*[]any)
-	})
+	var err error
+	st.buf, err = f.render(st.buf, cn, a, st)
 	if err != nil {
-		return ""
+		return 0, err
 	}
 
-	var s string
-	if aa == nil {
-		s = fmt.Sprintf(cn.format)
-	} else {
-		s = fmt.Sprintf(cn.format, (*aa)...)
-		f.argsBufPool.Put(aa)
+	return w.Write(st.buf)
+}
+
+func (f *Formatter) sprintf(cn *cachenode, a []any) string {
+	st := f.getState()
+	defer f.putState(st)
+
+	var err error
+	st.buf, err = f.render(st.buf, cn, a, st)
+	if err != nil {
+		return ""
 	}
 
-	return s
+	return string(st.buf)
 }
 
-func (f *Formatter) Errorf(format string, a ...any) error {
-	if len(a) == 0 {
-		return fmt.Errorf(format)
+func (f *Formatter) errorf(cn *cachenode, a []any) error {
+	if err := f.checkUnused(cn, a); err != nil {
+		return err
 	}
 
-	cn := f.cache.get(format)
+	st := f.getState()
+	defer f.putState(st)
 
-	aa, err := cn.construct(a, func() *[]any {
-		return f.argsBufPool.Get().(*[]any)
-	})
+	var err error
+	st.vals, err = cn.construct(a, st.vals[:0], f.opts.naming)
+	if err != nil {
+		return err
+	}
+	var wrapped []error
+	st.buf, wrapped, err = cn.render(st.buf, st.vals, &f.opts)
 	if err != nil {
 		return err
 	}
 
-	if aa == nil {
-		err = fmt.Errorf(cn.format)
-	} else {
-		err = fmt.Errorf(cn.format, (*aa)...)
-		f.argsBufPool.Put(aa)
+	return &Error{
+		msg:    string(st.buf),
+		format: cn.source,
+		fields: cn.fields(st.vals),
+		errs:   wrapped,
 	}
+}
+
+func (f *Formatter) appendf(b []byte, cn *cachenode, a []any) ([]byte, error) {
+	st := f.getState()
+	defer f.putState(st)
+
+	n := len(b)
+	b, err := f.render(b, cn, a, st)
+	if err != nil {
+		return b[:n], err
+	}
+	return b, nil
+}
+
+// Error is returned by Errorf, and keeps the format and the values of its names.
+type Error struct {
+	msg    string
+	format string
+	fields M
+	errs   []error
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+// Format returns the format of the error.
+func (e *Error) Format() string {
+	return e.format
+}
+
+// Fields returns the values of the names referred in the format.
+//
+// Names without values are not included.
+func (e *Error) Fields() M {
+	return maps.Clone(e.fields)
+}
 
-	return err
+// Unwrap returns errors of `w` verbs.
+func (e *Error) Unwrap() []error {
+	return e.errs
 }
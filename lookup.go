@@ -0,0 +1,133 @@
+package nmfmt
+
+import (
+	"reflect"
+)
+
+// pathError describes a path that cannot be followed.
+type pathError struct {
+	path string // the path up to the failed segment
+	kind string // NILPTR, MISSING, BADINDEX or BADPATH
+}
+
+func (e *pathError) Error() string {
+	switch e.kind {
+	case "NILPTR":
+		return "nmfmt: " + e.path + ": nil pointer"
+	case "MISSING":
+		return "nmfmt: " + e.path + ": not found"
+	case "BADINDEX":
+		return "nmfmt: " + e.path + ": index out of range"
+	default:
+		return "nmfmt: " + e.path + ": cannot be followed"
+	}
+}
+
+// walk follows path from v, which is the value of name.
+//
+// Fields of structs, elements of slices and arrays, values of maps and Lookupers, and pointers to them are followed.
+func walk(v any, name string, path []segment) (any, error) {
+	for i, seg := range path {
+		var ok bool
+
+		switch m := v.(type) {
+		case M:
+			if seg.kind == segIndex {
+				return nil, &pathError{path: joinPath(name, path[:i]), kind: "BADPATH"}
+			}
+			v, ok = m[seg.key]
+
+		case map[string]any:
+			if seg.kind == segIndex {
+				return nil, &pathError{path: joinPath(name, path[:i]), kind: "BADPATH"}
+			}
+			v, ok = m[seg.key]
+
+		case Lookuper:
+			if seg.kind == segIndex {
+				return nil, &pathError{path: joinPath(name, path[:i]), kind: "BADPATH"}
+			}
+			v, ok = m.Lookup(seg.key)
+
+		default:
+			rv := reflect.ValueOf(v)
+			for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+				if rv.IsNil() {
+					break
+				}
+				rv = rv.Elem()
+			}
+
+			var err error
+			v, ok, err = walkValue(rv, seg)
+			if err != nil {
+				kind := err.(errorString)
+				if kind == "BADINDEX" {
+					return nil, &pathError{path: joinPath(name, path[:i+1]), kind: string(kind)}
+				}
+				return nil, &pathError{path: joinPath(name, path[:i]), kind: string(kind)}
+			}
+		}
+
+		if !ok {
+			return nil, &pathError{path: joinPath(name, path[:i+1]), kind: "MISSING"}
+		}
+	}
+
+	return v, nil
+}
+
+// walkValue follows a step from rv.
+func walkValue(rv reflect.Value, seg segment) (any, bool, error) {
+	switch rv.Kind() {
+	case reflect.Invalid, reflect.Pointer, reflect.Interface:
+		return nil, false, errorString("NILPTR")
+
+	case reflect.Struct:
+		if seg.kind == segIndex {
+			break
+		}
+		f, found := rv.Type().FieldByName(seg.key)
+		if !found || !f.IsExported() {
+			return nil, false, nil
+		}
+		fv, err := rv.FieldByIndexErr(f.Index)
+		if err != nil { // through a nil embedded pointer
+			return nil, false, errorString("NILPTR")
+		}
+		return fv.Interface(), true, nil
+
+	case reflect.Map:
+		kt := rv.Type().Key()
+
+		var key reflect.Value
+		switch {
+		case seg.kind != segIndex && kt.Kind() == reflect.String:
+			key = reflect.ValueOf(seg.key).Convert(kt)
+		case seg.kind == segIndex && reflect.Int <= kt.Kind() && kt.Kind() <= reflect.Uint64:
+			key = reflect.ValueOf(seg.index).Convert(kt)
+		default:
+			return nil, false, errorString("BADPATH")
+		}
+
+		if mv := rv.MapIndex(key); mv.IsValid() {
+			return mv.Interface(), true, nil
+		}
+		return nil, false, nil
+
+	case reflect.Slice, reflect.Array:
+		if seg.kind != segIndex {
+			break
+		}
+		idx := seg.index
+		if idx < 0 {
+			idx += rv.Len()
+		}
+		if idx < 0 || rv.Len() <= idx {
+			return nil, false, errorString("BADINDEX")
+		}
+		return rv.Index(idx).Interface(), true, nil
+	}
+
+	return nil, false, errorString("BADPATH")
+}
@@ -2,33 +2,320 @@ package nmfmt
 
 import (
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
+// NamingStrategy decides names of struct fields without tags.
+type NamingStrategy int
+
+const (
+	// NameAsIs uses field names as they are: UserID. (default)
+	NameAsIs NamingStrategy = iota
+	// NameSnakeCase converts field names into snake_case: user_id.
+	NameSnakeCase
+	// NameCamelCase converts field names into camelCase: userID.
+	NameCamelCase
+)
+
+// Naming sets how names of struct fields without tags are converted.
+func Naming(s NamingStrategy) OptionFunc {
+	return func(f *formatterOptions) {
+		f.naming = s
+	}
+}
+
 // Struct returns a slice of names and values of fields from structs.
 //
 // If a key is duplicated among structs, the first found element wins.
 //
 // Note: An unexported field results in <nil>. (NO: name string; YES: Name string)
+//
+// A field is named by its `nmfmt` tag, or its `json` tag if not tagged by nmfmt.
+// `nmfmt:"-"` skips the field, and `nmfmt:"name,omitempty"` skips it if its value is zero.
+//
+// Every field is read and boxed into the slice, so Struct costs more than key/value pairs.
+// A struct passed directly as an arg, or structs chained by [Sources], read only fields referred in the format.
 func Struct(structs ...any) []any {
+	return f.Struct(structs...)
+}
+
+// Struct is like the package function Struct, with names of fields converted by the Naming option of f.
+func (f *Formatter) Struct(structs ...any) []any {
 	a := make([]any, 0, 8)
 
 	for i := len(structs) - 1; i >= 0; i-- {
-		s := structs[i]
-		v := reflect.Indirect(reflect.ValueOf(s))
-		if v.Type().Kind() != reflect.Struct {
+		s, ok := newStructArg(structs[i], f.opts.naming)
+		if !ok {
 			continue
 		}
 
-		for i := 0; i < v.NumField(); i++ {
-			ft := v.Type().Field(i)
-			fv := v.Field(i)
-
-			if ft.IsExported() {
-				a = append(a, ft.Name)
-				a = append(a, fv.Interface())
+		for j := range s.info.fields {
+			sf := &s.info.fields[j]
+			if len(sf.index) != 1 {
+				continue // promoted, and in its embedded struct
+			}
+			fv := s.v.Field(sf.index[0])
+			if sf.omitEmpty && fv.IsZero() {
+				continue
 			}
+			a = append(a, sf.name, fv.Interface())
 		}
 	}
 
 	return a
 }
+
+// structArg is an arg of fields of a struct.
+type structArg struct {
+	v    reflect.Value
+	info *structInfo
+}
+
+// newStructArg returns a structArg of v, if v is a struct or a non-nil pointer to a struct.
+func newStructArg(v any, naming NamingStrategy) (structArg, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return structArg{}, false
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return structArg{}, false
+	}
+	return structArg{v: rv, info: cachedStructInfo(rv.Type(), naming)}, true
+}
+
+// lookup returns the value of the field name.
+func (s *structArg) lookup(name string) (any, bool) {
+	i := s.info.find(name)
+	if i == -1 {
+		return nil, false
+	}
+
+	sf := &s.info.fields[i]
+	fv, ok := s.field(sf)
+	if !ok || sf.omitEmpty && fv.IsZero() {
+		return nil, false
+	}
+	return fv.Interface(), true
+}
+
+// eachName calls fn with the names of fields, except for empty ones to be omitted and embedded structs.
+func (s *structArg) eachName(fn func(name string)) {
+	for i := range s.info.fields {
+		sf := &s.info.fields[i]
+		if sf.embedded {
+			continue
+		}
+		fv, ok := s.field(sf)
+		if ok && (!sf.omitEmpty || !fv.IsZero()) {
+			fn(sf.name)
+		}
+	}
+}
+
+// field returns the value of sf, or false if it is in a nil embedded pointer.
+func (s *structArg) field(sf *structField) (reflect.Value, bool) {
+	if len(sf.index) == 1 {
+		return s.v.Field(sf.index[0]), true
+	}
+	fv, err := s.v.FieldByIndexErr(sf.index)
+	return fv, err == nil
+}
+
+// structInfo is metadata of fields of a struct type.
+type structInfo struct {
+	fields []structField
+	byName map[string]int // index of fields, the first one for duplicated names; nil for a few fields
+}
+
+// find returns the index of the field name in fields, or -1.
+func (info *structInfo) find(name string) int {
+	if info.byName == nil {
+		for i := range info.fields {
+			if info.fields[i].name == name {
+				return i
+			}
+		}
+		return -1
+	}
+
+	if i, found := info.byName[name]; found {
+		return i
+	}
+	return -1
+}
+
+type structField struct {
+	name      string
+	index     []int // of reflect.Value.FieldByIndex, through embedded structs
+	omitEmpty bool
+	embedded  bool // an embedded struct, whose fields are also promoted
+}
+
+// structInfos are caches of structInfo by NamingStrategy.
+var structInfos [NameCamelCase + 1]sync.Map // reflect.Type -> *structInfo
+
+// cachedStructInfo returns the structInfo of t, made once for a type and a naming.
+func cachedStructInfo(t reflect.Type, naming NamingStrategy) *structInfo {
+	if naming < NameAsIs || NameCamelCase < naming {
+		naming = NameAsIs
+	}
+	cache := &structInfos[naming]
+	if info, found := cache.Load(t); found {
+		return info.(*structInfo)
+	}
+
+	info := &structInfo{}
+	info.addFields(t, nil, naming, map[reflect.Type]bool{t: true})
+	if len(info.fields) > 8 {
+		info.byName = make(map[string]int, len(info.fields))
+		for i := len(info.fields) - 1; i >= 0; i-- {
+			info.byName[info.fields[i].name] = i
+		}
+	}
+
+	actual, _ := cache.LoadOrStore(t, info)
+	return actual.(*structInfo)
+}
+
+// addFields appends fields of t, and then those promoted from its embedded structs.
+//
+// An exported embedded struct is also a field by its type name, and one named by its tag is just a field.
+// visited guards against embedded pointers of the same type recursively.
+func (info *structInfo) addFields(t reflect.Type, index []int, naming NamingStrategy, visited map[reflect.Type]bool) {
+	type embedded struct {
+		t     reflect.Type
+		index []int
+	}
+	var embeds []embedded
+
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		name, omitEmpty, skip := fieldName(ft, naming)
+		if skip {
+			continue
+		}
+		fi := append(slices.Clip(index), i)
+
+		if ft.Anonymous && !tagged(ft) {
+			et := ft.Type
+			ptr := et.Kind() == reflect.Pointer
+			if ptr {
+				et = et.Elem()
+			}
+			// fields through an unexported pointer cannot be read
+			if et.Kind() == reflect.Struct && (ft.IsExported() || !ptr) {
+				if !visited[et] {
+					embeds = append(embeds, embedded{t: et, index: fi})
+				}
+				if ft.IsExported() {
+					info.fields = append(info.fields, structField{name: name, index: fi, omitEmpty: omitEmpty, embedded: true})
+				}
+				continue
+			}
+		}
+		if !ft.IsExported() {
+			continue
+		}
+
+		info.fields = append(info.fields, structField{name: name, index: fi, omitEmpty: omitEmpty})
+	}
+
+	for _, e := range embeds {
+		visited[e.t] = true
+		info.addFields(e.t, e.index, naming, visited)
+	}
+}
+
+// tagged reports whether ft is named by its tag.
+func tagged(ft reflect.StructField) bool {
+	tag, found := ft.Tag.Lookup("nmfmt")
+	if !found {
+		tag = ft.Tag.Get("json")
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	return name != "" && name != "-"
+}
+
+// fieldName returns the name of a field by its tags or naming.
+func fieldName(ft reflect.StructField, naming NamingStrategy) (name string, omitEmpty, skip bool) {
+	tag, found := ft.Tag.Lookup("nmfmt")
+	if !found {
+		tag, found = ft.Tag.Lookup("json")
+	}
+	if found {
+		if tag == "-" {
+			return "", false, true
+		}
+		var opts string
+		name, opts, _ = strings.Cut(tag, ",")
+		for opts != "" {
+			var opt string
+			opt, opts, _ = strings.Cut(opts, ",")
+			if opt == "omitempty" {
+				omitEmpty = true
+			}
+		}
+	}
+	if name != "" {
+		return name, omitEmpty, false
+	}
+
+	switch naming {
+	case NameSnakeCase:
+		return snakeCase(ft.Name), omitEmpty, false
+	case NameCamelCase:
+		return camelCase(ft.Name), omitEmpty, false
+	}
+	return ft.Name, omitEmpty, false
+}
+
+// snakeCase converts UserID into user_id, and HTTPServer into http_server.
+func snakeCase(name string) string {
+	rs := []rune(name)
+
+	var sb strings.Builder
+	sb.Grow(len(name) + 4)
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			if i > 0 && (!unicode.IsUpper(rs[i-1]) && rs[i-1] != '_' ||
+				i+1 < len(rs) && unicode.IsLower(rs[i+1]) && unicode.IsUpper(rs[i-1])) {
+				sb.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// camelCase converts UserID into userID, and HTTPServer into httpServer.
+func camelCase(name string) string {
+	// the leading upper case letters, except the first one of the next word
+	n := 0
+	for n < len(name) {
+		r, size := utf8.DecodeRuneInString(name[n:])
+		if !unicode.IsUpper(r) {
+			break
+		}
+		n += size
+	}
+	if n == 0 {
+		return name
+	}
+	if n < len(name) {
+		if _, size := utf8.DecodeLastRuneInString(name[:n]); n > size {
+			if r, _ := utf8.DecodeRuneInString(name[n:]); unicode.IsLower(r) {
+				n -= size
+			}
+		}
+	}
+
+	return strings.ToLower(name[:n]) + name[n:]
+}
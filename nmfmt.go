@@ -16,24 +16,132 @@
 //
 // # Name
 //
-// Must match \w.
+// Letters, digits and `_`, including non-ASCII ones like $名前 or $größe.
+// Use ${name} to separate a name from following letters: ${名前}さん.
+// In ${name}, a name may contain any characters other than spaces and .[]:{}|"$.
+//
+// A name can be followed by dotted segments like $user.Name or ${order.Customer.Address.City},
+// and subscripts like ${items[0]}, ${items[-1]} (from the end) or ${headers["Content-Type"]}.
+// They walk into struct fields, elements of slices and arrays, values of maps (including M), and pointers to them.
+// A path that cannot be followed is printed as a fmt style marker
+// like %!v(NILPTR=order.Customer.Address), %!v(MISSING=order.Customer.Nmae) or %!v(BADINDEX=items[5]).
 //
 // See [Named], [Struct]
 //
+// # Default
+//
+// Fallbacks follow the name with `|`, like ${name|"anonymous"} or $nick|$name|"?".
+// A fallback name is marked by `$`, and a name without `$` after `|` is a filter.
+// Since filters also follow `|`, a name without `$` could be a typo of a filter,
+// so `${nick|name}` is reported by [Compile] as an unknown filter. Write `${nick|$name}`.
+// The first name found and not nil wins. A quoted string or a number is used as it is.
+// A verb follows the last one: ${count|0:d}.
+//
+// # Filter
+//
+// Filters follow the name (and fallbacks) with `|`, and are applied left to right before the verb:
+// ${name|upper}, ${s|trim|lower}, ${title|trunc:20}, ${tags|join:", "}.
+// Args of a filter follow `:`. Quote an arg that looks like a verb.
+// In $name, only filters without args are available: $name|upper.
+//
+//   - upper, lower: converts the case.
+//   - trim[:chars]: removes leading and trailing spaces (or chars).
+//   - trunc:n[:suffix]: cuts into n runes, followed by suffix if cut.
+//   - join[:sep]: joins elements of a slice (sep defaults to ", ").
+//   - hex: encodes a string or bytes, or formats an integer in base 16.
+//   - base64: encodes a string or bytes.
+//   - len: the length of a slice, a map or the number of runes of a string.
+//   - plural:one:other: chooses one if the number is 1, otherwise other.
+//   - select:key=text:...: chooses the text of the key that matches the value, or of `other`.
+//   - percent[:prec]: formats a ratio as a percentage in the locale (0.125 -> 12.5%).
+//   - decimal[:prec]: formats a number with grouped digits in the locale.
+//
+// `${count} ${count|plural:"file":"files"}`, `${kind|select:admin="Administrator":other="Guest"}`
+//
+// More filters can be added by [Filters].
+// An unknown filter is reported by [Compile]. In $name, `|` followed by an unknown filter is left as text.
+// A failed filter makes a call fail with a [*FilterError].
+//
 // # Verb
 //
 // Verb is with `:`.
-// Must match \w.
+// Flags (-+# 0), a width and a precision can precede the verb character like fmt:
+// ${price:8.2f}, $id:-10s, ${x:+#08x}.
+// In ${name:verb}, a width or a precision can be taken from another arg: ${value:*width.*prec f}.
+// An invalid verb is reported by [Compile].
+//
+// A `,` flag groups digits of a number in the locale: ${n:,d} -> 1,234,567 (en), 1.234.567 (de).
+// See [Locale].
 //
 // Defaults to `v`.
 //
 // See https://pkg.go.dev/fmt.
 //
+// # Escape
+//
+// `$$` is a literal `$`. `$$5` -> `$5`, `$${HOME}` -> `${HOME}`
+//
+// `%` is a literal `%`. But Printf, Fprintf, Sprintf and Errorf pass a format without args to fmt as it is:
+// Sprintf("50%% done") returns "50% done", and Sprintf("hello $name") returns "hello $name".
+//
 // # debug notation
 //
 // If a placeholder starts with `$=`, the output starts with the name of the placeholder followed by `=`.
 //
 // `$=name` -> `name=NAME_VALUE`
+//
+// # Sections
+//
+// ${?name}...${/name} prints its body only if the value of name is truthy,
+// and ${^name}...${/name} only if it is not.
+// false, zero numbers, empty strings, slices and maps, nil and missing values are not truthy.
+// The name can be a path like ${?user.Admin}, and sections can be nested.
+//
+// `Rejected${?reason} (reason: $reason)${/reason}.`
+//
+// ${#name}...${/name} prints its body for each element of a slice, an array or a map (in order of keys).
+// In the body, names are looked up in the element first, and then in args.
+// $@index, $@first, $@last, $@key (of a map) and $@value (the element itself) are also available.
+// A separator is put between elements by ${#name sep=", "}.
+//
+// `${#items}- $name x $qty\n${/items}`, `${#tags sep=", "}$@value${/tags}`
+//
+// # Missing keys
+//
+// A placeholder without its value is printed as <nil> by default.
+// Create a Formatter with [MissingKey] to print nothing, keep the placeholder,
+// or fail with a [*MissingKeyError] listing every unresolved name.
+//
+// # Args
+//
+// Args are key/value pairs, mixed with sources: an M or any map with string keys, a struct or a pointer to a struct,
+// a [Lookuper], or slog.Attr and []slog.Attr (groups are walked like $req.id).
+// A name is looked up in args in order, and the first one having it wins.
+// [Sources] chains sources into one Lookuper in the same way.
+//
+// Fields of a struct are named by `nmfmt` or `json` tags, or by [Naming], and those of embedded structs are promoted.
+// [Struct] makes args of fields of structs.
+//
+// # Unused args
+//
+// Args not referred in the format are ignored by default.
+// [StrictArgs] makes a call fail with an [*UnusedArgsError], and [OnUnusedArgs] reports them through a callback.
+//
+// # Messages
+//
+// A [Catalog] holds formats of messages by IDs and languages, and is set by [Messages].
+// [Formatter.Localize] returns a Formatter for a language, whose [Formatter.T] formats a message by its ID.
+// A message not found in the language is looked up in less specific ones, and then in the fallback: ja-JP, ja, en.
+//
+// # Errors
+//
+// Errorf returns an [*Error], which keeps the format and the values of its names besides the message.
+// Errors of `w` verbs ($err:w) are unwrapped by errors.Is and errors.As. A value not an error is printed like fmt: %!w(int=5).
+//
+// # Compile
+//
+// A format can be parsed in advance by [Compile].
+// The resulting [Template] reports malformed placeholders at once, and skips the cache lookup on each call.
 package nmfmt
 
 import (
@@ -44,6 +152,14 @@ var f Formatter = New()
 
 type M map[string]any
 
+// Lookuper provides values of names on formatting.
+//
+// A Lookuper as an arg is asked for names in the format, instead of building an M.
+type Lookuper interface {
+	// Lookup returns the value of name, and false if it is not found (then the MissingKeyPolicy applies).
+	Lookup(name string) (any, bool)
+}
+
 func Printf(format string, a ...any) (int, error) {
 	return f.Printf(format, a...)
 }
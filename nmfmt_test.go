@@ -5,8 +5,11 @@ package nmfmt_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -55,6 +58,35 @@ func ExampleStruct() {
 	// Kim is 22 years old.
 }
 
+func ExampleCompile() {
+	t := nmfmt.MustCompile("$name is $age years old.\n")
+
+	t.Printf("name", "Kim", "age", 22)
+	t.Printf(nmfmt.M{"name": "Lee", "age": 23})
+
+	// Output:
+	// Kim is 22 years old.
+	// Lee is 23 years old.
+}
+
+func ExampleFormatter_Localize() {
+	c := nmfmt.NewCatalog("en")
+	c.Add("en", map[string]string{
+		"greeting": "Hello, $name! You have $count ${count|plural:message:messages}.\n",
+	})
+	c.Add("ja", map[string]string{
+		"greeting": "${name}さん、メッセージが${count}件あります。\n",
+	})
+
+	f := nmfmt.New(nmfmt.Messages(c))
+	fmt.Print(f.Localize("en-US").T("greeting", nmfmt.M{"name": "Kim", "count": 1}))
+	fmt.Print(f.Localize("ja-JP").T("greeting", nmfmt.M{"name": "Kim", "count": 2}))
+
+	// Output:
+	// Hello, Kim! You have 1 message.
+	// Kimさん、メッセージが2件あります。
+}
+
 func TestNotation(t *testing.T) {
 	t.Run("Boundary", func(t *testing.T) {
 		gotwant.Test(t, nmfmt.Sprintf("hello, $Name.", "Name", "Hoge"), "hello, Hoge.")
@@ -89,6 +121,676 @@ func TestNotation(t *testing.T) {
 	})
 }
 
+func TestPath(t *testing.T) {
+	type Address struct {
+		City string
+	}
+	type Customer struct {
+		Name    string
+		Address *Address
+	}
+	type Order struct {
+		Customer Customer
+		Limits   map[string]int
+	}
+
+	order := Order{
+		Customer: Customer{Name: "Kim", Address: &Address{City: "Seoul"}},
+		Limits:   map[string]int{"max": 10},
+	}
+
+	gotwant.Test(t, nmfmt.Sprintf("$order.Customer.Name in ${order.Customer.Address.City}", "order", order), "Kim in Seoul")
+	gotwant.Test(t, nmfmt.Sprintf("${ order.Limits.max :03d}", "order", &order), "010")
+	gotwant.Test(t, nmfmt.Sprintf("$=cfg.limits.max", nmfmt.M{"cfg": nmfmt.M{"limits": map[string]any{"max": 10}}}), "cfg.limits.max=10")
+	gotwant.Test(t, nmfmt.Sprintf("$order.", "order", "Kim"), "Kim.")
+
+	t.Run("Broken", func(t *testing.T) {
+		order.Customer.Address = nil
+		gotwant.Test(t, nmfmt.Sprintf("$order.Customer.Address.City", "order", order), "%!v(NILPTR=order.Customer.Address)")
+		gotwant.Test(t, nmfmt.Sprintf("$order.Customer.Nmae:q", "order", order), "%!q(MISSING=order.Customer.Nmae)")
+		gotwant.Test(t, nmfmt.Sprintf("$order.Limits.min", "order", order), "%!v(MISSING=order.Limits.min)")
+		gotwant.Test(t, nmfmt.Sprintf("$order.Customer.Name.Len", "order", order), "%!v(BADPATH=order.Customer.Name)")
+		gotwant.Test(t, nmfmt.Sprintf("$order.Customer.Name", "order", (*Order)(nil)), "%!v(NILPTR=order)")
+		gotwant.Test(t, nmfmt.Sprintf("$order.Customer.Name", nmfmt.M{}), "<nil>")
+	})
+}
+
+func TestSubscript(t *testing.T) {
+	items := []string{"apple", "banana", "cherry"}
+	headers := map[string]string{"Content-Type": "text/plain"}
+
+	gotwant.Test(t, nmfmt.Sprintf("${items[0]}, $items[1] and ${ items[ -1 ] :q}", "items", items), `apple, banana and "cherry"`)
+	gotwant.Test(t, nmfmt.Sprintf(`${headers["Content-Type"]}`, "headers", headers), "text/plain")
+	gotwant.Test(t, nmfmt.Sprintf(`${=m.list[1]["key"]}`, "m", nmfmt.M{"list": []any{nil, nmfmt.M{"key": 42}}}), `m.list[1]["key"]=42`)
+	gotwant.Test(t, nmfmt.Sprintf("${codes[404]}", "codes", map[int]string{404: "Not Found"}), "Not Found")
+	gotwant.Test(t, nmfmt.Sprintf("${arr[1]}", "arr", [2]int{1, 2}), "2")
+	gotwant.Test(t, nmfmt.Sprintf("$price[USD]", "price", 5), "5[USD]")
+
+	t.Run("Broken", func(t *testing.T) {
+		gotwant.Test(t, nmfmt.Sprintf("${items[3]}", "items", items), "%!v(BADINDEX=items[3])")
+		gotwant.Test(t, nmfmt.Sprintf("${items[-4]:q}", "items", items), "%!q(BADINDEX=items[-4])")
+		gotwant.Test(t, nmfmt.Sprintf(`${headers["Accept"]}`, "headers", headers), `%!v(MISSING=headers["Accept"])`)
+		gotwant.Test(t, nmfmt.Sprintf(`${headers[0]}`, "headers", headers), `%!v(BADPATH=headers)`)
+	})
+
+	t.Run("Syntax", func(t *testing.T) {
+		_, err := nmfmt.Compile("${items[}")
+		gotwant.TestError(t, err, "invalid subscript at offset 7")
+		_, err = nmfmt.Compile("${items[0}")
+		gotwant.TestError(t, err, "unclosed subscript at offset 7")
+		_, err = nmfmt.Compile(`${items["a]}`)
+		gotwant.TestError(t, err, "unclosed string literal at offset 7")
+	})
+}
+
+func TestDefault(t *testing.T) {
+	gotwant.Test(t, nmfmt.Sprintf(`Hello, ${name|"anonymous"}.`, nmfmt.M{}), "Hello, anonymous.")
+	gotwant.Test(t, nmfmt.Sprintf(`Hello, ${name|"anonymous"}.`, "name", "Kim"), "Hello, Kim.")
+	gotwant.Test(t, nmfmt.Sprintf(`Hello, $name|"anonymous".`, nmfmt.M{}), "Hello, anonymous.")
+
+	gotwant.Test(t, nmfmt.Sprintf(`${nick|$name|"?"}`, "name", "Kim"), "Kim")
+	gotwant.Test(t, nmfmt.Sprintf(`${ nick | $name | "?" }`, nmfmt.M{"nick": nil, "name": "Kim"}), "Kim")
+	gotwant.Test(t, nmfmt.Sprintf(`$nick|$name|"?"`, "nick", "K", "name", "Kim"), "K")
+	gotwant.Test(t, nmfmt.Sprintf(`${nick|$user.Name|"?"}`, "user", nmfmt.M{}), "?")
+	gotwant.Test(t, nmfmt.Sprintf(`${nick|$name}`, nmfmt.M{}), "<nil>")
+
+	gotwant.Test(t, nmfmt.Sprintf(`${count|0:d} files`, nmfmt.M{}), "0 files")
+	gotwant.Test(t, nmfmt.Sprintf(`$count|0:d files`, "count", 3), "3 files")
+	gotwant.Test(t, nmfmt.Sprintf(`${ratio|0.5:.2f}`, nmfmt.M{}), "0.50")
+	gotwant.Test(t, nmfmt.Sprintf(`$=count|-1.`, nmfmt.M{}), "count=-1.")
+
+	_, err := nmfmt.Compile(`${nick|name|"?"}`)
+	gotwant.TestError(t, err, "unknown filter \"name\" (a fallback name needs `$`) at offset 7")
+	gotwant.Test(t, nmfmt.Sprintf(`$nick|name`, "name", "Kim"), "<nil>|name")
+
+	_, err = nmfmt.Compile(`${name|$}`)
+	gotwant.TestError(t, err, "empty name at offset 7")
+	_, err = nmfmt.Compile(`${name|"anonymous}`)
+	gotwant.TestError(t, err, "unclosed string literal at offset 7")
+}
+
+func TestMissingKey(t *testing.T) {
+	format := "$=name, ${user.Address.City:q}, $nick|$name"
+	user := struct{ Address *struct{ City string } }{}
+
+	cases := []struct {
+		policy nmfmt.MissingKeyPolicy
+		want   string
+	}{
+		{policy: nmfmt.MissingNil, want: "name=<nil>, %!q(NILPTR=user.Address), <nil>"},
+		{policy: nmfmt.MissingEmpty, want: "name=, , "},
+		{policy: nmfmt.MissingKeep, want: "$=name, ${user.Address.City:q}, $nick|$name"},
+	}
+	for _, c := range cases {
+		f := nmfmt.New(nmfmt.MissingKey(c.policy))
+		gotwant.Test(t, f.Sprintf(format, "user", user), c.want)
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		f := nmfmt.New(nmfmt.MissingKey(nmfmt.MissingError))
+
+		buf := &bytes.Buffer{}
+		n, err := f.Fprintf(buf, format+", $name", "user", user)
+		gotwant.Test(t, n, 0)
+		gotwant.Test(t, buf.String(), "")
+		gotwant.Test(t, err, &nmfmt.MissingKeyError{Names: []string{"name", "user.Address.City", "nick"}})
+		gotwant.TestError(t, err, "missing keys: name, user.Address.City, nick")
+
+		err = f.Errorf("$nmae is $age", "name", "Kim", "age", 22)
+		var merr *nmfmt.MissingKeyError
+		gotwant.Test(t, errors.As(err, &merr), true)
+		gotwant.Test(t, merr.Names, []string{"nmae"})
+
+		tmpl, _ := f.Compile(`${nick|$name|"?"} ${items[3]}`)
+		_, err = tmpl.Fprintf(buf, "items", []int{1, 2, 3})
+		gotwant.TestError(t, err, "missing keys: items[3]")
+
+		gotwant.Test(t, f.Sprintf("$name is $age", "name", "Kim", "age", 22), "Kim is 22")
+	})
+}
+
+func TestUnusedArgs(t *testing.T) {
+	t.Run("Strict", func(t *testing.T) {
+		f := nmfmt.New(nmfmt.StrictArgs())
+
+		err := f.Errorf("$name", "name", "Kim", "age", 22)
+		gotwant.Test(t, err, &nmfmt.UnusedArgsError{Format: "$name", Names: []string{"age"}})
+		gotwant.TestError(t, err, "unused args: age")
+
+		_, err = f.Fprintf(&bytes.Buffer{}, "$nick|$name", nmfmt.M{"name": "Kim", "age": 22, "id": 1})
+		gotwant.TestError(t, err, "unused args: age, id")
+
+		tmpl, _ := f.Compile("$Name")
+		_, err = tmpl.Fprintf(&bytes.Buffer{}, nmfmt.Struct(struct{ Name, Item string }{})...)
+		gotwant.TestError(t, err, "unused args: Item")
+
+		_, err = f.Fprintf(&bytes.Buffer{}, "$a", 1, 2, "a", 3)
+		gotwant.TestError(t, err, "unused args: !BADKEY(int=1)")
+
+		gotwant.Test(t, f.Sprintf("$name is $age", "name", "Kim", "age", 22), "Kim is 22")
+	})
+
+	t.Run("Func", func(t *testing.T) {
+		var got []string
+		f := nmfmt.New(nmfmt.OnUnusedArgs(func(format string, names []string) {
+			got = append(got, format)
+			got = append(got, names...)
+		}))
+
+		gotwant.Test(t, f.Sprintf("$name", "name", "Kim", "age", 22), "Kim")
+		gotwant.Test(t, got, []string{"$name", "age"})
+	})
+}
+
+func TestErrorf(t *testing.T) {
+	base := errors.New("base")
+
+	err := nmfmt.Errorf("$Name: $Err:w (${Err})", "Name", "Hoge", "Err", base)
+	gotwant.Test(t, err.Error(), "Hoge: base (base)")
+	gotwant.Test(t, errors.Is(err, base), true)
+
+	other := errors.New("other")
+	err = nmfmt.Errorf("${Err.Name}: ${Err:w}, ${Other|$Err:w}", "Err", base, "Other", other)
+	gotwant.Test(t, err.Error(), "%!v(MISSING=Err.Name): base, other")
+	gotwant.Test(t, errors.Is(err, base), true)
+	gotwant.Test(t, errors.Is(err, other), true)
+
+	err = nmfmt.Errorf("$Name: $Err", "Name", "Hoge", "Err", base)
+	gotwant.Test(t, err.Error(), "Hoge: base")
+	gotwant.Test(t, errors.Is(err, base), false)
+
+	t.Run("Error", func(t *testing.T) {
+		err := nmfmt.Errorf("user $userID: ${op|upper} failed: $err:w", "userID", 42, "op", "save", "err", base, "extra", 1)
+
+		var nerr *nmfmt.Error
+		gotwant.Test(t, errors.As(fmt.Errorf("wrapped: %w", err), &nerr), true)
+		gotwant.Test(t, nerr.Error(), "user 42: SAVE failed: base")
+		gotwant.Test(t, nerr.Format(), "user $userID: ${op|upper} failed: $err:w")
+		gotwant.Test(t, nerr.Fields(), nmfmt.M{"userID": 42, "op": "save", "err": base})
+		gotwant.Test(t, nerr.Unwrap(), []error{base})
+
+		// Fields is a copy
+		nerr.Fields()["userID"] = 0
+		gotwant.Test(t, nerr.Fields()["userID"], 42)
+
+		// without values or `w` verbs
+		err = nmfmt.Errorf("$a and $b", nmfmt.M{"a": nil})
+		gotwant.Test(t, errors.As(err, &nerr), true)
+		gotwant.Test(t, nerr.Error(), "<nil> and <nil>")
+		gotwant.Test(t, nerr.Fields(), nmfmt.M{"a": nil})
+		gotwant.Test(t, nerr.Unwrap() == nil, true)
+
+		tmpl := nmfmt.MustCompile("$name: $err:w")
+		err = tmpl.Errorf("name", "x", "err", base)
+		gotwant.Test(t, errors.As(err, &nerr), true)
+		gotwant.Test(t, nerr.Fields(), nmfmt.M{"name": "x", "err": base})
+	})
+}
+
+func TestEscape(t *testing.T) {
+	gotwant.Test(t, nmfmt.Sprintf("$item costs $$5", "item", "Potion"), "Potion costs $5")
+	gotwant.Test(t, nmfmt.Sprintf("echo $$HOME $${HOME} $$$name", "name", "Kim"), "echo $HOME ${HOME} $Kim")
+	gotwant.Test(t, nmfmt.Sprintf("$$", nmfmt.M{}), "$")
+	gotwant.Test(t, nmfmt.Sprintf("$$$$", nmfmt.M{}), "$$")
+
+	_, err := nmfmt.Compile("$${")
+	gotwant.TestError(t, err, nil)
+}
+
+func TestSpec(t *testing.T) {
+	gotwant.Test(t, nmfmt.Sprintf("[${price:8.2f}]", "price", 3.14159), "[    3.14]")
+	gotwant.Test(t, nmfmt.Sprintf("[$id:-10s]", "id", "abc"), "[abc       ]")
+	gotwant.Test(t, nmfmt.Sprintf("[${x:+#08x}]", "x", 255), "[+0x00000ff]")
+	gotwant.Test(t, nmfmt.Sprintf("[${ x : %5d }]", "x", 42), "[   42]")
+	gotwant.Test(t, nmfmt.Sprintf("[$x:.3f]", "x", 1.0), "[1.000]")
+	gotwant.Test(t, nmfmt.Sprintf("[$x:5]", "x", 1), "[1:5]")
+	gotwant.Test(t, nmfmt.Sprintf("$user:admin", "user", "Kim"), "Kim:admin")
+
+	t.Run("Star", func(t *testing.T) {
+		gotwant.Test(t, nmfmt.Sprintf("[${value:*width.*prec f}]", "value", 3.14159, "width", 8, "prec", 3), "[   3.142]")
+		gotwant.Test(t, nmfmt.Sprintf("[${value:*width.prec f}]", "value", 3.14159, "width", int64(8), "prec", uint8(1)), "[     3.1]")
+		gotwant.Test(t, nmfmt.Sprintf("[${value:-*width d}]", "value", 1, "width", 3), "[1  ]")
+		gotwant.Test(t, nmfmt.Sprintf("[${value:*width d}]", "value", 1, "width", "3"), "[%!(BADWIDTH)1]")
+		gotwant.Test(t, nmfmt.ExtractNames("${value:*width.*prec f}"), map[string]struct{}{"value": {}, "width": {}, "prec": {}})
+
+		f := nmfmt.New(nmfmt.MissingKey(nmfmt.MissingError))
+		gotwant.TestError(t, f.Errorf("${value:*width d}", "value", 1), "missing keys: width")
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		cases := []struct {
+			format string
+			msg    string
+		}{
+			{format: "${x:}", msg: "empty verb at offset 4"},
+			{format: "${x:z}", msg: `invalid verb "z" at offset 4`},
+			{format: "${x:8.2}", msg: `invalid verb "8.2" at offset 4`},
+			{format: "${x:dd}", msg: `invalid verb "dd" at offset 4`},
+			{format: "${x:*}", msg: "empty width name at offset 4"},
+			{format: "${x:.* d}", msg: "empty precision name at offset 4"},
+			{format: "${x:.*d}", msg: `invalid verb ".*d" at offset 4`},
+		}
+		for _, c := range cases {
+			_, err := nmfmt.Compile(c.format)
+			gotwant.TestError(t, err, c.msg, gotwant.Desc(c.format))
+		}
+	})
+}
+
+func TestFilter(t *testing.T) {
+	gotwant.Test(t, nmfmt.Sprintf("${name|upper}, $name|lower", "name", "Kim"), "KIM, kim")
+	gotwant.Test(t, nmfmt.Sprintf("[${s|trim|lower}]", "s", "  Hello "), "[hello]")
+	gotwant.Test(t, nmfmt.Sprintf(`[${s|trim:"-"}]`, "s", "--a--"), "[a]")
+	gotwant.Test(t, nmfmt.Sprintf("${title|trunc:5}", "title", "こんにちは世界"), "こんにちは")
+	gotwant.Test(t, nmfmt.Sprintf(`${title|trunc:5:"…"}`, "title", "Hello, world"), "Hello…")
+	gotwant.Test(t, nmfmt.Sprintf(`${tags|join:", "}`, "tags", []string{"a", "b"}), "a, b")
+	gotwant.Test(t, nmfmt.Sprintf(`${tags|join:"/"|upper:q}`, "tags", []any{"a", 1}), `"A/1"`)
+	gotwant.Test(t, nmfmt.Sprintf("${v|hex} ${n|hex:4s}", "v", "AB", "n", 255), "4142   ff")
+	gotwant.Test(t, nmfmt.Sprintf("${data|base64}", "data", []byte("hello")), "aGVsbG8=")
+	gotwant.Test(t, nmfmt.Sprintf("${items|len:03d}", "items", []int{1, 2}), "002")
+	gotwant.Test(t, nmfmt.Sprintf(`${nick|$name|"?"|upper}`, "name", "Kim"), "KIM")
+	gotwant.Test(t, nmfmt.Sprintf("${upper}", "upper", "as a name"), "as a name")
+	gotwant.Test(t, nmfmt.Sprintf("${name|$upper.x}", "upper", nmfmt.M{"x": "fallback"}), "fallback")
+	gotwant.Test(t, nmfmt.Sprintf("$name|upper|x", "name", "Kim"), "KIM|x")
+
+	f := nmfmt.New()
+	_, err := f.Fprintf(&bytes.Buffer{}, "${title|trunc:-1}", "title", "Hello")
+	gotwant.TestError(t, err, `title|trunc: invalid length "-1"`)
+	var ferr *nmfmt.FilterError
+	gotwant.Test(t, errors.As(err, &ferr), true)
+
+	t.Run("Invalid", func(t *testing.T) {
+		cases := []struct {
+			format string
+			msg    string
+		}{
+			{format: "${name|upper|nick}", msg: `unknown filter "nick" at offset 13`},
+			{format: `${name|upper|"x"}`, msg: `fallback after filters at offset 13`},
+			{format: "${name|nick}", msg: "unknown filter \"nick\" (a fallback name needs `$`) at offset 7"},
+			{format: "${name|upper.x}", msg: `unexpected '.' in placeholder at offset 12`},
+			{format: "${name|}", msg: `empty filter name at offset 7`},
+			{format: "${name|trunc}", msg: `too few args for filter "trunc" at offset 12`},
+			{format: "${name|upper:z}", msg: `invalid verb "z" at offset 13`},
+			{format: "${name|trunc:1:a:zz}", msg: `invalid verb "zz" at offset 17`},
+			{format: `${name|trunc:1:a:"b"}`, msg: `too many args for filter "trunc" at offset 16`},
+			{format: "${name|join:}", msg: `empty filter arg at offset 12`},
+		}
+		for _, c := range cases {
+			_, err := nmfmt.Compile(c.format)
+			gotwant.TestError(t, err, c.msg, gotwant.Desc(c.format))
+		}
+	})
+}
+
+func TestPlural(t *testing.T) {
+	format := `${count} ${count|plural:"file":"files"}`
+
+	for _, c := range []struct {
+		count any
+		want  string
+	}{
+		{count: 0, want: "0 files"},
+		{count: 1, want: "1 file"},
+		{count: 2, want: "2 files"},
+		{count: -1, want: "-1 file"},
+		{count: int8(1), want: "1 file"},
+		{count: int64(3), want: "3 files"},
+		{count: uint(1), want: "1 file"},
+		{count: uint16(11), want: "11 files"},
+		{count: 1.0, want: "1 file"},
+		{count: float32(1.5), want: "1.5 files"},
+	} {
+		gotwant.Test(t, nmfmt.Sprintf(format, "count", c.count), c.want, gotwant.Desc(fmt.Sprintf("%T", c.count)))
+	}
+
+	gotwant.Test(t, nmfmt.Sprintf("${n|plural:child:children:q}", "n", 2), `"children"`)
+
+	f := nmfmt.New()
+	_, err := f.Fprintf(&bytes.Buffer{}, `${count|plural:"file":"files"}`, "count", "1")
+	gotwant.TestError(t, err, "count|plural: not a number: string")
+
+	_, err = nmfmt.Compile(`${count|plural:"file"}`)
+	gotwant.TestError(t, err, `too few args for filter "plural" at offset 21`)
+}
+
+func TestSelect(t *testing.T) {
+	format := `${kind|select:admin="Administrator":user="User":other="Guest"}`
+
+	gotwant.Test(t, nmfmt.Sprintf(format, "kind", "admin"), "Administrator")
+	gotwant.Test(t, nmfmt.Sprintf(format, "kind", "user"), "User")
+	gotwant.Test(t, nmfmt.Sprintf(format, "kind", "bot"), "Guest")
+	gotwant.Test(t, nmfmt.Sprintf(`${ok|select:true=yes:false=no}`, "ok", true), "yes")
+	gotwant.Test(t, nmfmt.Sprintf(`${kind|select:a="x: y":b=z|upper}`, "kind", "a"), "X: Y")
+	gotwant.Test(t, nmfmt.Sprintf(`${kind|select:a=x:other=y:8s}`, "kind", "a"), "       x")
+
+	f := nmfmt.New()
+	_, err := f.Fprintf(&bytes.Buffer{}, `${kind|select:admin=A}`, "kind", "user")
+	gotwant.TestError(t, err, `kind|select: no case for "user"`)
+	_, err = f.Fprintf(&bytes.Buffer{}, `${kind|select:admin}`, "kind", "user")
+	gotwant.TestError(t, err, `kind|select: invalid case "admin"`)
+
+	_, err = nmfmt.Compile(`${kind|select:a="x}`)
+	gotwant.TestError(t, err, "unclosed string literal at offset 14")
+}
+
+func TestUserFilter(t *testing.T) {
+	f := nmfmt.New(nmfmt.Filters(map[string]func(v any, args ...string) (any, error){
+		"money": func(v any, args ...string) (any, error) {
+			n, ok := v.(int)
+			if !ok {
+				return nil, fmt.Errorf("not an int: %T", v)
+			}
+			if len(args) == 0 {
+				return fmt.Sprintf("%d", n), nil
+			}
+			return fmt.Sprintf("%s %d", args[0], n), nil
+		},
+		"mask": func(v any, args ...string) (any, error) {
+			return strings.Repeat("*", len(fmt.Sprint(v))), nil
+		},
+		"upper": func(v any, args ...string) (any, error) {
+			return "UPPER", nil
+		},
+	}))
+
+	gotwant.Test(t, f.Sprintf("${amount|money:JPY}", "amount", 100), "JPY 100")
+	gotwant.Test(t, f.Sprintf("${amount|money:JPY:6s}", "amount", 100), "JPY 100")
+	gotwant.Test(t, f.Sprintf("${amount|money:q}", "amount", 100), `"100"`)
+	gotwant.Test(t, f.Sprintf(`${amount|money:"q"}`, "amount", 100), "q 100")
+	gotwant.Test(t, f.Sprintf("$user|mask, ${user|lower|mask}", "user", "Kim"), "***, ***")
+	gotwant.Test(t, f.Sprintf("${user|upper}", "user", "Kim"), "UPPER")
+	gotwant.Test(t, f.Sprintf("${user|$mask}", "mask", "as a fallback"), "as a fallback")
+	gotwant.Test(t, f.Sprintf("$user|msak", "user", "Kim"), "Kim|msak")
+
+	err := f.Errorf("${amount|money}", "amount", "100")
+	gotwant.TestError(t, err, "amount|money: not an int: string")
+	var ferr *nmfmt.FilterError
+	gotwant.Test(t, errors.As(err, &ferr), true)
+	gotwant.Test(t, ferr.Filter, "money")
+
+	_, err = f.Compile("${amount|money|monye}")
+	gotwant.TestError(t, err, `unknown filter "monye" at offset 15`)
+	_, err = f.Compile("${amount|monye:JPY}")
+	gotwant.TestError(t, err, `unknown filter "monye" at offset 9`)
+	_, err = f.Compile("${user|msak}")
+	gotwant.TestError(t, err, "unknown filter \"msak\" (a fallback name needs `$`) at offset 7")
+	_, err = nmfmt.Compile("${user|mask}")
+	gotwant.TestError(t, err, "unknown filter \"mask\" (a fallback name needs `$`) at offset 7")
+	_, err = f.Compile("${user|mask|$nick}")
+	gotwant.TestError(t, err, `fallback after filters at offset 12`)
+}
+
+func TestUnicodeName(t *testing.T) {
+	gotwant.Test(t, nmfmt.Sprintf("こんにちは、$名前。", "名前", "金"), "こんにちは、金。")
+	gotwant.Test(t, nmfmt.Sprintf("${名前}さん", "名前", "金"), "金さん")
+	gotwant.Test(t, nmfmt.Sprintf("$名前さん", "名前", "金"), "<nil>")
+	gotwant.Test(t, nmfmt.Sprintf("$größe cm, ${größe:05.1f}", "größe", 180.5), "180.5 cm, 180.5")
+	gotwant.Test(t, nmfmt.Sprintf("$ユーザー.名前:q", "ユーザー", nmfmt.M{"名前": "金"}), `"金"`)
+	gotwant.Test(t, nmfmt.Sprintf("$ß|$名前", "名前", "x"), "x")
+	gotwant.Test(t, nmfmt.Sprintf("$é", "é", 1), "1")             // precomposed
+	gotwant.Test(t, nmfmt.Sprintf("$e\u0301", "e\u0301", 1), "1") // combining acute accent
+	gotwant.Test(t, nmfmt.Sprintf("$١٢", "١٢", 12), "12")
+	gotwant.Test(t, nmfmt.Sprintf("$x→$y", "x", 1, "y", 2), "1→2")
+
+	gotwant.Test(t, nmfmt.ExtractNames("$名前、$größe, ${ユーザー.名前}"), map[string]struct{}{"名前": {}, "größe": {}, "ユーザー": {}})
+}
+
+func TestSlogAttr(t *testing.T) {
+	gotwant.Test(t, nmfmt.Sprintf("$name is $age", slog.String("name", "Kim"), slog.Int("age", 22)), "Kim is 22")
+	gotwant.Test(t, nmfmt.Sprintf("$name is $age", "name", "Kim", slog.Int("age", 22)), "Kim is 22")
+	gotwant.Test(t, nmfmt.Sprintf("$name is $age", []slog.Attr{slog.String("name", "Kim"), slog.Int("age", 22)}), "Kim is 22")
+	gotwant.Test(t, nmfmt.Sprintf("$req.method $req.path", slog.Group("req", "method", "GET", "path", "/")), "GET /")
+	gotwant.Test(t, nmfmt.Sprintf("$method", slog.Group("", "method", "GET")), "GET")
+	gotwant.Test(t, nmfmt.Sprintf("$t", slog.Time("t", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))), "2024-01-02 00:00:00 +0000 UTC")
+	gotwant.Test(t, nmfmt.Sprintf("$v", slog.Any("v", logValuer{})), "resolved")
+	gotwant.Test(t, nmfmt.Sprintf("$name", slog.Int("age", 22)), "<nil>")
+
+	f := nmfmt.New(nmfmt.StrictArgs())
+	_, err := f.Fprintf(&bytes.Buffer{}, "$name", []slog.Attr{slog.String("name", "Kim"), slog.Int("age", 22)}, slog.Group("", "x", 1))
+	gotwant.TestError(t, err, "unused args: age, x")
+
+	gotwant.Test(t, nmfmt.ExtractPaths(`$req.method ${req["path"]}|x $items[0]:q`), map[string]struct{}{
+		"req.method":  {},
+		`req["path"]`: {},
+		"items[0]":    {},
+	})
+}
+
+type logValuer struct{}
+
+func (logValuer) LogValue() slog.Value {
+	return slog.StringValue("resolved")
+}
+
+func TestSection(t *testing.T) {
+	format := "Rejected${?reason} (reason: $reason)${/reason}${^reason} without reason${/reason}."
+
+	gotwant.Test(t, nmfmt.Sprintf(format, "reason", "too late"), "Rejected (reason: too late).")
+	gotwant.Test(t, nmfmt.Sprintf(format, nmfmt.M{}), "Rejected without reason.")
+	gotwant.Test(t, nmfmt.Sprintf(format, "reason", ""), "Rejected without reason.")
+	gotwant.Test(t, nmfmt.Sprintf(format, nmfmt.M{"reason": nil}), "Rejected without reason.")
+
+	truthy := []any{true, 1, 0.5, "a", []int{1}, map[string]int{"a": 1}, &struct{}{}, struct{}{}}
+	for _, v := range truthy {
+		gotwant.Test(t, nmfmt.Sprintf("${?v}yes${/v}", "v", v), "yes", gotwant.Desc(fmt.Sprintf("%#v", v)))
+	}
+	falsy := []any{false, 0, 0.0, "", []int{}, map[string]int{}, (*struct{})(nil), nil}
+	for _, v := range falsy {
+		gotwant.Test(t, nmfmt.Sprintf("${?v}yes${/v}", "v", v), "", gotwant.Desc(fmt.Sprintf("%#v", v)))
+	}
+
+	t.Run("Nested", func(t *testing.T) {
+		format := "${?user}${ ? user.Admin }[admin] ${/ user.Admin }$user.Name${/user}"
+		gotwant.Test(t, nmfmt.Sprintf(format, "user", nmfmt.M{"Name": "Kim", "Admin": true}), "[admin] Kim")
+		gotwant.Test(t, nmfmt.Sprintf(format, "user", nmfmt.M{"Name": "Lee"}), "Lee")
+		gotwant.Test(t, nmfmt.Sprintf(format, nmfmt.M{}), "")
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		f := nmfmt.New(nmfmt.MissingKey(nmfmt.MissingError))
+		gotwant.Test(t, f.Sprintf(format, nmfmt.M{}), "Rejected without reason.")
+		gotwant.TestError(t, f.Errorf("${^ok}$detail${/ok}", nmfmt.M{}), "missing keys: detail")
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		cases := []struct {
+			format string
+			msg    string
+		}{
+			{format: "${?reason} $reason", msg: "unclosed section ${?reason} at offset 0"},
+			{format: "$reason${/reason}", msg: "unmatched ${/reason} at offset 7"},
+			{format: "${?a}${?b}${/a}${/b}", msg: "unmatched ${/a} at offset 10"},
+			{format: "${?a:q}${/a}", msg: `unexpected ':' in section at offset 4`},
+		}
+		for _, c := range cases {
+			_, err := nmfmt.Compile(c.format)
+			gotwant.TestError(t, err, c.msg, gotwant.Desc(c.format))
+		}
+
+		gotwant.Test(t, nmfmt.Sprintf("${?reason} $reason", "reason", "x"), "${?reason} x")
+	})
+}
+
+func TestLoop(t *testing.T) {
+	type item struct {
+		Name string
+		Qty  int
+	}
+
+	format := "${#items}- $name x $qty\n${/items}"
+
+	gotwant.Test(t, nmfmt.Sprintf(format, "items", []nmfmt.M{
+		{"name": "apple", "qty": 3},
+		{"name": "banana", "qty": 1},
+	}), "- apple x 3\n- banana x 1\n")
+	gotwant.Test(t, nmfmt.Sprintf(format, "items", []map[string]any{{"name": "apple", "qty": 3}}), "- apple x 3\n")
+	gotwant.Test(t, nmfmt.Sprintf("${#items}- $Name x $Qty\n${/items}", "items", []item{{"apple", 3}, {"banana", 1}}), "- apple x 3\n- banana x 1\n")
+	gotwant.Test(t, nmfmt.Sprintf("${#items}$Name${/items}", "items", &[2]*item{{Name: "a"}, {Name: "b"}}), "ab")
+	gotwant.Test(t, nmfmt.Sprintf(format, "items", []nmfmt.M{}), "")
+	gotwant.Test(t, nmfmt.Sprintf(format, nmfmt.M{}), "")
+	gotwant.Test(t, nmfmt.Sprintf(format, "items", 1), "")
+
+	t.Run("Sep", func(t *testing.T) {
+		gotwant.Test(t, nmfmt.Sprintf(`${#tags sep=", "}$@value${/tags}`, "tags", []string{"a", "b", "c"}), "a, b, c")
+		gotwant.Test(t, nmfmt.Sprintf(`${# tags sep="\t" }$@value${/tags}`, "tags", []string{"a", "b"}), "a\tb")
+	})
+
+	t.Run("Meta", func(t *testing.T) {
+		format := "${#tags}${?@first}[${/@first}$@index:$@value${?@last}]${/@last}${^@last} ${/@last}${/tags}"
+		gotwant.Test(t, nmfmt.Sprintf(format, "tags", []string{"a", "b", "c"}), "[0:a 1:b 2:c]")
+		gotwant.Test(t, nmfmt.Sprintf(format, "tags", []string{"a"}), "[0:a]")
+
+		gotwant.Test(t, nmfmt.Sprintf(`${#m sep=" "}$@key=$@value${/m}`, "m", map[string]int{"b": 2, "a": 1, "c": 3}), "a=1 b=2 c=3")
+		gotwant.Test(t, nmfmt.Sprintf(`${#m sep=" "}$@key=$name${/m}`, "m", map[int]nmfmt.M{10: {"name": "x"}, 2: {"name": "y"}}), "2=y 10=x")
+	})
+
+	t.Run("Scope", func(t *testing.T) {
+		format := "${#items}$name$unit|${/items}"
+		gotwant.Test(t, nmfmt.Sprintf(format, "unit", "kg", "items", []nmfmt.M{{"name": "a"}, {"name": "b", "unit": "g"}}), "akg|bg|")
+
+		// nested
+		format = `${#groups sep="; "}$name: ${#members sep=", "}$name@$host${/members}${/groups}`
+		groups := []nmfmt.M{
+			{"name": "dev", "members": []nmfmt.M{{"name": "kim"}, {"name": "lee", "host": "b"}}},
+			{"name": "ops", "members": []nmfmt.M{}},
+		}
+		gotwant.Test(t, nmfmt.Sprintf(format, "groups", groups, "host", "a"), "dev: kim@a, lee@b; ops: ")
+
+		// paths and fallbacks in an element
+		format = `${#users sep=","}${Address.City|"-"}${/users}`
+		type address struct{ City string }
+		type user struct{ Address *address }
+		gotwant.Test(t, nmfmt.Sprintf(format, "users", []user{{&address{"Tokyo"}}, {nil}}), "Tokyo,-")
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		f := nmfmt.New(nmfmt.MissingKey(nmfmt.MissingError))
+		gotwant.TestError(t, f.Errorf("${#items}$name$unit${/items}", "items", []nmfmt.M{{"name": "a"}}), "missing keys: unit")
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		cases := []struct {
+			format string
+			msg    string
+		}{
+			{format: "${#items}$name", msg: "unclosed section ${#items} at offset 0"},
+			{format: "${#items sep=,}${/items}", msg: "separator must be a quoted string at offset 13"},
+			{format: `${#items sep=", }${/items}`, msg: "unclosed string literal at offset 13"},
+			{format: `${#items sepp=","}${/items}`, msg: "unexpected 's' in section at offset 9"},
+		}
+		for _, c := range cases {
+			_, err := nmfmt.Compile(c.format)
+			gotwant.TestError(t, err, c.msg, gotwant.Desc(c.format))
+		}
+	})
+}
+
+func TestCatalog(t *testing.T) {
+	c := nmfmt.NewCatalog("en")
+	c.Set("en", "greeting", "Hello, $name.")
+	c.Set("en", "bye", "Bye, $name.")
+	c.Set("ja", "greeting", "こんにちは、${name}さん。")
+	c.Set("ja_JP", "bye", "さようなら、${name}さん。")
+	c.Set("zh-Hant-TW", "greeting", "$name，你好。")
+
+	cases := []struct {
+		lang, id, want string
+	}{
+		{lang: "en", id: "greeting", want: "Hello, Kim."},
+		{lang: "ja", id: "greeting", want: "こんにちは、Kimさん。"},
+		{lang: "ja-JP", id: "greeting", want: "こんにちは、Kimさん。"},
+		{lang: "ja-jp", id: "bye", want: "さようなら、Kimさん。"},
+		{lang: "ja", id: "bye", want: "Bye, Kim."},
+		{lang: "fr", id: "greeting", want: "Hello, Kim."},
+		{lang: "", id: "greeting", want: "Hello, Kim."},
+		{lang: "zh-Hant-TW", id: "greeting", want: "Kim，你好。"},
+		{lang: "zh-Hant", id: "greeting", want: "Hello, Kim."},
+		{lang: "ja", id: "unknown $name", want: "unknown $name"},
+	}
+
+	f := nmfmt.New(nmfmt.Messages(c))
+	for _, c := range cases {
+		gotwant.Test(t, f.Localize(c.lang).T(c.id, "name", "Kim"), c.want, gotwant.Desc(c.lang+" "+c.id))
+	}
+
+	format, found := c.Lookup("ja-JP", "greeting")
+	gotwant.Test(t, format, "こんにちは、${name}さん。")
+	gotwant.Test(t, found, true)
+	_, found = c.Lookup("ja-JP", "unknown")
+	gotwant.Test(t, found, false)
+
+	// default language
+	gotwant.Test(t, f.T("bye", "name", "Lee"), "Bye, Lee.")
+
+	// without a catalog
+	f = nmfmt.New()
+	gotwant.Test(t, f.Localize("ja").T("greeting", "name", "Kim"), "greeting")
+
+	// options are inherited
+	f = nmfmt.New(nmfmt.Messages(c), nmfmt.MissingKey(nmfmt.MissingKeep))
+	gotwant.Test(t, f.Localize("ja").T("greeting"), "こんにちは、${name}さん。")
+}
+
+func TestNumber(t *testing.T) {
+	t.Run("Group", func(t *testing.T) {
+		gotwant.Test(t, nmfmt.Sprintf("${n:,d}", "n", 1234567), "1,234,567")
+		gotwant.Test(t, nmfmt.Sprintf("${n:,d}", "n", -123456), "-123,456")
+		gotwant.Test(t, nmfmt.Sprintf("${n:,d}", "n", 123), "123")
+		gotwant.Test(t, nmfmt.Sprintf("${n:,d}", "n", uint64(18446744073709551615)), "18,446,744,073,709,551,615")
+		gotwant.Test(t, nmfmt.Sprintf("${n:,.2f}", "n", 1234567.5), "1,234,567.50")
+		gotwant.Test(t, nmfmt.Sprintf("${n:+,.1f}", "n", 1234.5), "+1,234.5")
+		gotwant.Test(t, nmfmt.Sprintf("[${n:,12d}]", "n", 1234567), "[   1,234,567]")
+		gotwant.Test(t, nmfmt.Sprintf("[${n:-,12d}]", "n", 1234567), "[1,234,567   ]")
+		gotwant.Test(t, nmfmt.Sprintf("[${n:,*w.*p f}]", "n", 1234.5, "w", 10, "p", 1), "[   1,234.5]")
+		gotwant.Test(t, nmfmt.Sprintf("$n:,d", "n", 1234), "1,234")
+		gotwant.Test(t, nmfmt.Sprintf("${n:,v}", "n", "abc"), "abc")
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		gotwant.Test(t, nmfmt.Sprintf("${ratio|percent}", "ratio", 0.126), "13%")
+		gotwant.Test(t, nmfmt.Sprintf("${ratio|percent:1}", "ratio", 0.125), "12.5%")
+		gotwant.Test(t, nmfmt.Sprintf("${ratio|percent:1}", "ratio", 1), "100.0%")
+		gotwant.Test(t, nmfmt.Sprintf("${price|decimal:2}", "price", 1234567.5), "1,234,567.50")
+		gotwant.Test(t, nmfmt.Sprintf("${price|decimal}", "price", 1234567.5), "1,234,567.5")
+		gotwant.Test(t, nmfmt.Sprintf("${price|decimal:2}", "price", int64(-1234567)), "-1,234,567.00")
+		gotwant.Test(t, nmfmt.Sprintf("[${price|decimal:2:12s}]", "price", 1234.5), "[    1,234.50]")
+		gotwant.Test(t, nmfmt.Sprintf("${price|decimal|len}", "price", 1234), "5")
+		gotwant.Test(t, nmfmt.Sprintf("${p|decimal}", "p", float32(0.1)), "0.1")
+		gotwant.Test(t, nmfmt.Sprintf("${p|percent:1}", "p", float32(0.125)), "12.5%")
+
+		f := nmfmt.New()
+		_, err := f.Fprintf(&bytes.Buffer{}, "${price|decimal}", "price", "1")
+		gotwant.TestError(t, err, "price|decimal: not a number: string")
+		_, err = f.Fprintf(&bytes.Buffer{}, "${price|decimal:-1}", "price", 1)
+		gotwant.TestError(t, err, `price|decimal: invalid precision "-1"`)
+	})
+
+	t.Run("Locale", func(t *testing.T) {
+		format := "${n:,.1f} ${n|decimal:1} ${r|percent:1}"
+		cases := []struct {
+			lang, want string
+		}{
+			{lang: "en", want: "1,234,567.5 1,234,567.5 12.5%"},
+			{lang: "en-US", want: "1,234,567.5 1,234,567.5 12.5%"},
+			{lang: "de", want: "1.234.567,5 1.234.567,5 12,5\u00a0%"},
+			{lang: "de-CH", want: "1’234’567.5 1’234’567.5 12.5%"},
+			{lang: "fr_FR", want: "1\u202f234\u202f567,5 1\u202f234\u202f567,5 12,5\u202f%"},
+			{lang: "ja-JP", want: "1,234,567.5 1,234,567.5 12.5%"},
+			{lang: "xx", want: "1,234,567.5 1,234,567.5 12.5%"},
+		}
+		for _, c := range cases {
+			f := nmfmt.New(nmfmt.Locale(c.lang))
+			gotwant.Test(t, f.Sprintf(format, "n", 1234567.5, "r", 0.125), c.want, gotwant.Desc(c.lang))
+		}
+
+		// follows Localize unless Locale is set
+		f := nmfmt.New()
+		gotwant.Test(t, f.Localize("de").Sprintf("${n:,d}", "n", 1234), "1.234")
+		f = nmfmt.New(nmfmt.Locale("fr"))
+		gotwant.Test(t, f.Localize("de").Sprintf("${n:,d}", "n", 1234), "1\u202f234")
+	})
+}
+
 func TestVSStd(t *testing.T) {
 	cases := []struct {
 		stdinput string
@@ -203,6 +905,25 @@ func TestVSStd(t *testing.T) {
 			nminput:  "${=Power:d}%daze",
 			nmargs:   []any{"Power", 99},
 		},
+		{
+			desc:     "w of not an error",
+			stdinput: "x: %w",
+			stdargs:  []any{5},
+			nminput:  "x: $x:w",
+			nmargs:   []any{"x", 5},
+		},
+		{
+			desc:     "w of nil",
+			stdinput: "x: %w",
+			stdargs:  []any{nil},
+			nminput:  "x: $x:w",
+			nmargs:   []any{"x", nil},
+		},
+		{
+			desc:     "no args",
+			stdinput: "50%% done",
+			nminput:  "50%% done",
+		},
 	}
 
 	// also shows how they are inconpatible
@@ -241,7 +962,7 @@ func TestVSStd(t *testing.T) {
 			nms := nmfmt.Errorf(c.nminput, c.nmargs...)
 
 			if !c.inconpatible {
-				gotwant.Test(t, nms, stds, gotwant.Format("%q"))
+				gotwant.Test(t, nms.Error(), stds.Error(), gotwant.Format("%q"))
 			}
 		}
 	})
@@ -268,6 +989,273 @@ func TestStruct(t *testing.T) {
 	gotwant.Test(t, nmfmt.Sprintf(f, a...), want)
 }
 
+func TestStructTag(t *testing.T) {
+	type user struct {
+		UserID   int    `nmfmt:"id"`
+		Name     string `json:"name,omitempty"`
+		Nick     string `nmfmt:",omitempty" json:"nickname"`
+		Password string `nmfmt:"-"`
+		Token    string `json:"-"`
+		Email    string `json:",omitempty"`
+		HTTPPort int
+		name     string
+	}
+
+	// names not found are kept
+	f := nmfmt.New(nmfmt.MissingKey(nmfmt.MissingKeep))
+	format := "$id $name $Nick $nickname $Password $Token $Email $HTTPPort $UserID $name"
+
+	u := user{UserID: 1, Name: "Kim", Password: "secret", Token: "t", HTTPPort: 8080, name: "x"}
+	gotwant.Test(t, nmfmt.Struct(u), []any{"id", 1, "name", "Kim", "HTTPPort", 8080})
+	gotwant.Test(t, f.Sprintf(format, nmfmt.Struct(u)...), "1 Kim $Nick $nickname $Password $Token $Email 8080 $UserID Kim")
+	gotwant.Test(t, nmfmt.Sprintf("$id:$name ${Nick|$name}", nmfmt.Struct(u)...), "1:Kim Kim")
+
+	u.Nick, u.Email = "k", "k@example.com"
+	gotwant.Test(t, nmfmt.Struct(&u), []any{"id", 1, "name", "Kim", "Nick", "k", "Email", "k@example.com", "HTTPPort", 8080})
+	gotwant.Test(t, f.Sprintf(format, nmfmt.Struct(&u)...), "1 Kim k $nickname $Password $Token k@example.com 8080 $UserID Kim")
+
+	t.Run("Naming", func(t *testing.T) {
+		type account struct {
+			UserID     int
+			HTTPServer string
+			ID         int
+			Name2      string
+			Tagged     bool `nmfmt:"TAG"`
+		}
+		a := account{UserID: 1, HTTPServer: "s", ID: 2, Name2: "n", Tagged: true}
+
+		f := nmfmt.New(nmfmt.Naming(nmfmt.NameSnakeCase))
+		gotwant.Test(t, f.Struct(a), []any{"user_id", 1, "http_server", "s", "id", 2, "name2", "n", "TAG", true})
+		gotwant.Test(t, f.Sprintf("$user_id@$http_server", f.Struct(a)...), "1@s")
+
+		f = nmfmt.New(nmfmt.Naming(nmfmt.NameSnakeCase), nmfmt.StrictArgs())
+		gotwant.Test(t, f.Sprintf("$user_id $http_server $id $name2 $TAG", f.Struct(a)...), "1 s 2 n true")
+
+		f = nmfmt.New(nmfmt.Naming(nmfmt.NameCamelCase), nmfmt.StrictArgs())
+		gotwant.Test(t, f.Struct(a), []any{"userID", 1, "httpServer", "s", "id", 2, "name2", "n", "TAG", true})
+		gotwant.Test(t, f.Sprintf("$userID $httpServer $id $name2 $TAG", f.Struct(a)...), "1 s 2 n true")
+
+		f = nmfmt.New(nmfmt.StrictArgs())
+		gotwant.Test(t, f.Struct(a), []any{"UserID", 1, "HTTPServer", "s", "ID", 2, "Name2", "n", "TAG", true})
+		gotwant.Test(t, f.Sprintf("$UserID $HTTPServer $ID $Name2 $TAG", f.Struct(a)...), "1 s 2 n true")
+		_, err := f.Fprintf(&bytes.Buffer{}, "$UserID", f.Struct(a)...)
+		gotwant.TestError(t, err, "unused args: HTTPServer, ID, Name2, TAG")
+	})
+
+	t.Run("Args", func(t *testing.T) {
+		type item struct{ Name string }
+		a := append(nmfmt.Struct(item{Name: "Potion"}, (*item)(nil), 1), "Count", 3)
+		gotwant.Test(t, nmfmt.Sprintf("$Count x $Name", a...), "3 x Potion")
+	})
+}
+
+func TestStructArg(t *testing.T) {
+	type Base struct {
+		ID   int
+		Name string
+	}
+	type Meta struct{ Tag string }
+	type user struct {
+		Base
+		*Meta
+		Name string `nmfmt:"nick"`
+		Age  int
+	}
+
+	u := user{Base: Base{ID: 1, Name: "Kim"}, Meta: &Meta{Tag: "vip"}, Name: "k", Age: 22}
+	gotwant.Test(t, nmfmt.Sprintf("$ID $Name ($nick) $Age $Tag", u), "1 Kim (k) 22 vip")
+	gotwant.Test(t, nmfmt.Sprintf("$ID $Name ($nick) $Age $Tag", &u), "1 Kim (k) 22 vip")
+	gotwant.Test(t, nmfmt.Sprintf("$Base.ID $Meta.Tag", u), "1 vip")
+
+	// Struct lists embedded structs as they are
+	gotwant.Test(t, nmfmt.Struct(u), []any{"Base", u.Base, "Meta", u.Meta, "nick", "k", "Age", 22})
+
+	// a nil embedded pointer has no fields
+	u.Meta = nil
+	gotwant.Test(t, nmfmt.Sprintf("$Name $Tag", u), "Kim <nil>")
+	gotwant.Test(t, nmfmt.Sprintf("$Name", (*user)(nil)), "<nil>")
+
+	t.Run("Shadowed", func(t *testing.T) {
+		type inner struct{ Name, Inner string }
+		type outer struct {
+			inner
+			Name string
+		}
+		gotwant.Test(t, nmfmt.Sprintf("$Name $Inner", outer{inner: inner{Name: "in", Inner: "i"}, Name: "out"}), "out i")
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		f := nmfmt.New(nmfmt.Naming(nmfmt.NameSnakeCase), nmfmt.StrictArgs())
+		gotwant.Test(t, f.Sprintf("$id $name $nick $age $tag", &u), "1 Kim k 22 <nil>")
+
+		_, err := f.Fprintf(&bytes.Buffer{}, "$id $name", &u)
+		gotwant.TestError(t, err, "unused args: age, nick")
+
+		gotwant.TestError(t, f.Errorf("$id", Base{ID: 1}), "unused args: name")
+	})
+
+	t.Run("Attr", func(t *testing.T) {
+		gotwant.Test(t, nmfmt.Sprintf("$user", slog.String("user", "kim")), "kim")
+	})
+}
+
+func TestMapArg(t *testing.T) {
+	type key string
+	type counts map[key]uint
+
+	gotwant.Test(t, nmfmt.Sprintf("$name $Age", map[string]any{"name": "Kim", "Age": 22}), "Kim 22")
+	gotwant.Test(t, nmfmt.Sprintf("$host:$port", map[string]string{"host": "localhost", "port": "80"}), "localhost:80")
+	gotwant.Test(t, nmfmt.Sprintf("$ok/$ng", map[string]int{"ok": 3, "ng": 1}), "3/1")
+	gotwant.Test(t, nmfmt.Sprintf("$ok/$ng", counts{"ok": 3}), "3/<nil>")
+	gotwant.Test(t, nmfmt.Sprintf("$ok", counts(nil)), "<nil>")
+	gotwant.Test(t, nmfmt.Sprintf("$cfg.port", map[string]map[string]int{"cfg": {"port": 80}}), "80")
+
+	// not a string key
+	gotwant.Test(t, nmfmt.Sprintf("$1", map[int]string{1: "one"}), "<nil>")
+
+	f := nmfmt.New(nmfmt.StrictArgs(), nmfmt.MissingKey(nmfmt.MissingError))
+	_, err := f.Fprintf(&bytes.Buffer{}, "$ok", counts{"ok": 3, "ng": 1})
+	gotwant.TestError(t, err, "unused args: ng")
+	_, err = f.Fprintf(&bytes.Buffer{}, "$ok $ng", map[string]string{"ok": "3"})
+	gotwant.TestError(t, err, "missing keys: ng")
+}
+
+// env is a Lookuper counting lookups.
+type env struct {
+	vars  map[string]any
+	calls int
+}
+
+func (e *env) Lookup(name string) (any, bool) {
+	e.calls++
+	v, found := e.vars[name]
+	return v, found
+}
+
+func TestLookuper(t *testing.T) {
+	e := &env{vars: map[string]any{"user": "kim", "debug": nil}}
+
+	gotwant.Test(t, nmfmt.Sprintf("$user", e), "kim")
+	gotwant.Test(t, e.calls, 1)
+
+	f := nmfmt.New(nmfmt.MissingKey(nmfmt.MissingKeep))
+	gotwant.Test(t, f.Sprintf("$user $debug $home", e), "kim <nil> $home")
+
+	f = nmfmt.New(nmfmt.MissingKey(nmfmt.MissingError), nmfmt.StrictArgs())
+	_, err := f.Fprintf(&bytes.Buffer{}, "$user $home", e)
+	gotwant.TestError(t, err, "missing keys: home")
+	_, err = f.Fprintf(&bytes.Buffer{}, "$debug", e)
+	gotwant.TestError(t, err, nil)
+
+	t.Run("Args", func(t *testing.T) {
+		gotwant.Test(t, nmfmt.Sprintf("$user: $n", "n", 1, e), "kim: 1")
+		gotwant.Test(t, nmfmt.Sprintf("$user", "user", "lee", e), "lee")
+	})
+
+	t.Run("Path", func(t *testing.T) {
+		gotwant.Test(t, nmfmt.Sprintf("$env.user ${env[0]}", "env", e), "kim %!v(BADPATH=env)")
+	})
+}
+
+func TestSources(t *testing.T) {
+	type user struct {
+		Name string
+		Role string
+	}
+	req := nmfmt.M{"path": "/", "user": "guest"}
+	defaults := map[string]string{"status": "ok", "path": "-"}
+	u := &user{Name: "kim", Role: "admin"}
+
+	gotwant.Test(t, nmfmt.Sprintf("$Name $path $status $user", nmfmt.Sources(req, u, defaults)), "kim / ok guest")
+	gotwant.Test(t, nmfmt.Sprintf("$path", nmfmt.Sources(defaults, req)), "-")
+	gotwant.Test(t, nmfmt.Sprintf("$path $x", nmfmt.Sources()), "<nil> <nil>")
+
+	t.Run("Nested", func(t *testing.T) {
+		e := &env{vars: map[string]any{"home": "/home/kim"}}
+		gotwant.Test(t, nmfmt.Sprintf("$home $path", nmfmt.Sources(e, nmfmt.Sources(req))), "/home/kim /")
+		gotwant.Test(t, nmfmt.Sprintf("$src.path", "src", nmfmt.Sources(req)), "/")
+	})
+
+	t.Run("Args", func(t *testing.T) {
+		// earlier args win
+		gotwant.Test(t, nmfmt.Sprintf("$path $status $n", req, "n", 1, "path", "/x", defaults), "/ ok 1")
+		gotwant.Test(t, nmfmt.Sprintf("$path $status $n", "path", "/x", req, "n", 1), "/x <nil> 1")
+		gotwant.Test(t, nmfmt.Sprintf("$Role $n", u, "n", 1), "admin 1")
+		gotwant.Test(t, nmfmt.Sprintf("$Role $n", (*user)(nil), "n", 1), "<nil> 1")
+	})
+
+	t.Run("Unused", func(t *testing.T) {
+		f := nmfmt.New(nmfmt.StrictArgs(), nmfmt.Naming(nmfmt.NameSnakeCase))
+		_, err := f.Fprintf(&bytes.Buffer{}, "$name $path", f.Sources(req, u))
+		gotwant.TestError(t, err, "unused args: role, user")
+		_, err = f.Fprintf(&bytes.Buffer{}, "$name $path $n", req, u, "n", 1, "m", 2)
+		gotwant.TestError(t, err, "unused args: m, role, user")
+	})
+}
+
+func TestAppendf(t *testing.T) {
+	f := nmfmt.New(nmfmt.MissingKey(nmfmt.MissingError))
+
+	b, err := f.Appendf([]byte("> "), "$name is $age", "name", "Kim", "age", 22)
+	gotwant.TestError(t, err, nil)
+	gotwant.Test(t, string(b), "> Kim is 22")
+
+	b, err = f.Appendf([]byte("> "), "$name is $age", "name", "Kim")
+	gotwant.TestError(t, err, "missing keys: age")
+	gotwant.Test(t, string(b), "> ")
+
+	tmpl, err := f.Compile("hi $name")
+	gotwant.TestError(t, err, nil)
+	b, err = tmpl.Appendf([]byte("> "), "nick", "Kim")
+	gotwant.TestError(t, err, "missing keys: name")
+	gotwant.Test(t, string(b), "> ")
+
+	sf := nmfmt.New(nmfmt.StrictArgs())
+	tmpl, err = sf.Compile("hi $name")
+	gotwant.TestError(t, err, nil)
+	b, err = tmpl.Appendf(nil, "name", "Kim", "age", 22)
+	gotwant.TestError(t, err, "unused args: age")
+	gotwant.Test(t, len(b), 0)
+}
+
+func TestCompile(t *testing.T) {
+	t.Run("OK", func(t *testing.T) {
+		tmpl, err := nmfmt.Compile("$=Name:q is ${ Age }.")
+		gotwant.TestError(t, err, nil)
+		gotwant.Test(t, tmpl.Sprintf("Name", "Hoge", "Age", 22), `Name="Hoge" is 22.`)
+		gotwant.Test(t, tmpl.Errorf("Name", "Hoge", "Age", 22).Error(), `Name="Hoge" is 22.`)
+		b, err := tmpl.Appendf([]byte("> "), "Name", "Hoge", "Age", 22)
+		gotwant.TestError(t, err, nil)
+		gotwant.Test(t, string(b), `> Name="Hoge" is 22.`)
+
+		buf := &bytes.Buffer{}
+		tmpl.Fprintf(buf, nmfmt.M{"Name": "Hoge", "Age": 22})
+		gotwant.Test(t, buf.String(), `Name="Hoge" is 22.`)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		cases := []struct {
+			format string
+			msg    string
+		}{
+			{format: "hello, ${Name", msg: "unclosed placeholder at offset 7"},
+			{format: "hello, ${}", msg: "empty name at offset 7"},
+			{format: "hello, ${ :q}", msg: "empty name at offset 7"},
+			{format: "hello, ${=}", msg: "empty name at offset 7"},
+			{format: "$Name ${Name} ${", msg: "unclosed placeholder at offset 14"},
+		}
+		for _, c := range cases {
+			_, err := nmfmt.Compile(c.format)
+			gotwant.TestError(t, err, c.msg, gotwant.Desc(c.format))
+
+			var serr *nmfmt.SyntaxError
+			gotwant.Test(t, errors.As(err, &serr), true, gotwant.Desc(c.format))
+		}
+
+		gotwant.TestPanic(t, func() { nmfmt.MustCompile("${") }, &nmfmt.SyntaxError{Format: "${", Offset: 0, Msg: "unclosed placeholder"})
+	})
+}
+
 func TestExtractNames(t *testing.T) {
 	cases := []struct {
 		format string
@@ -293,6 +1281,26 @@ func TestExtractNames(t *testing.T) {
 			"name": {},
 			"age":  {},
 		}},
+		{format: "$user.Name, ${ user.Age }, $=order.ID", names: map[string]struct{}{
+			"user":  {},
+			"order": {},
+		}},
+		{format: "$$name, $${age}"},
+		{format: `${nick|$name|"?"}, $age|0`, names: map[string]struct{}{
+			"nick": {},
+			"name": {},
+			"age":  {},
+		}},
+		{format: "${amount|money:JPY} $user|mask ${nick|lower|mask}", names: map[string]struct{}{
+			"amount": {},
+			"user":   {},
+			"nick":   {},
+		}},
+		{format: "${?reason}$reason${/reason}, ${#items}$name ${#tags}$@value${/tags}${/items}$total", names: map[string]struct{}{
+			"reason": {},
+			"items":  {},
+			"total":  {},
+		}},
 	}
 
 	for _, c := range cases {
@@ -325,6 +1333,27 @@ func BenchmarkFprintf(b *testing.B) {
 	})
 }
 
+func BenchmarkTemplate(b *testing.B) {
+	tmpl := nmfmt.MustCompile("$Name's age is $Age, and has $Item")
+
+	b.Run("Fprintf", func(b *testing.B) {
+		buf := &bytes.Buffer{}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			tmpl.Fprintf(buf, "Name", "Player", "Age", i, "Item", "Potion")
+		}
+	})
+
+	b.Run("Appendf", func(b *testing.B) {
+		var buf []byte
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			buf, _ = tmpl.Appendf(buf[:0], "Name", "Player", "Age", i, "Item", "Potion")
+		}
+	})
+}
+
 func BenchmarkSprintf(b *testing.B) {
 	b.Run("std", func(b *testing.B) {
 		b.ResetTimer()
@@ -415,4 +1444,31 @@ func BenchmarkArgType(b *testing.B) {
 				)...)
 		}
 	})
+	b.Run("StructDirect", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			nmfmt.Sprintf(
+				"$Name's age is $Age, and has $Item",
+				struct {
+					Name string
+					Age  int
+					Item string
+				}{Name: "Player", Age: i, Item: "Potion"},
+			)
+		}
+	})
+
+	b.Run("Sources", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			nmfmt.Sprintf(
+				"$Name's age is $Age, and has $Item",
+				nmfmt.Sources(struct {
+					Name string
+					Age  int
+				}{Name: "Player", Age: 123},
+					struct{ Item string }{Item: "Potion"},
+				))
+		}
+	})
 }
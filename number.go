@@ -0,0 +1,214 @@
+package nmfmt
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// numberLocale is how numbers are written in a locale.
+type numberLocale struct {
+	group   string // thousands separator
+	decimal string // decimal separator
+	percent string // suffix of a percentage
+}
+
+// numberLocales are built-in locales, looked up like ja-JP, ja.
+var numberLocales = map[string]numberLocale{
+	"en":    {group: ",", decimal: ".", percent: "%"},
+	"ja":    {group: ",", decimal: ".", percent: "%"},
+	"zh":    {group: ",", decimal: ".", percent: "%"},
+	"ko":    {group: ",", decimal: ".", percent: "%"},
+	"de":    {group: ".", decimal: ",", percent: "\u00a0%"},
+	"de-ch": {group: "’", decimal: ".", percent: "%"},
+	"es":    {group: ".", decimal: ",", percent: "\u00a0%"},
+	"it":    {group: ".", decimal: ",", percent: "%"},
+	"nl":    {group: ".", decimal: ",", percent: "%"},
+	"pt":    {group: ".", decimal: ",", percent: "%"},
+	"fr":    {group: "\u202f", decimal: ",", percent: "\u202f%"},
+	"fr-ch": {group: "\u202f", decimal: ".", percent: "%"},
+	"ru":    {group: "\u00a0", decimal: ",", percent: "\u00a0%"},
+	"pl":    {group: "\u00a0", decimal: ",", percent: "%"},
+	"sv":    {group: "\u00a0", decimal: ",", percent: "\u00a0%"},
+}
+
+// lookupNumberLocale returns the built-in locale of lang, or of en if not found.
+func lookupNumberLocale(lang string) numberLocale {
+	lang = normalizeLang(lang)
+	for lang != "" {
+		if loc, found := numberLocales[lang]; found {
+			return loc
+		}
+
+		i := strings.LastIndexByte(lang, '-')
+		if i == -1 {
+			break
+		}
+		lang = lang[:i]
+	}
+	return numberLocales["en"]
+}
+
+// Locale sets the locale of numbers printed by the `,` flag, percent and decimal filters.
+//
+// Defaults to the language of [Formatter.Localize], or en.
+// Built-in locales are en, ja, zh, ko, de, de-CH, es, it, nl, pt, fr, fr-CH, ru, pl and sv.
+func Locale(lang string) OptionFunc {
+	return func(f *formatterOptions) {
+		f.locale = lang
+	}
+}
+
+// setNumbers sets o.numbers of o.locale, or of o.lang.
+func (o *formatterOptions) setNumbers() {
+	lang := o.locale
+	if lang == "" {
+		lang = o.lang
+	}
+	o.numbers = lookupNumberLocale(lang)
+}
+
+// localize rewrites a number like -1234567.5 in loc.
+//
+// The integer part is grouped by 3 digits. A string not starting with a number is returned as it is.
+func (loc numberLocale) localize(b []byte, s string) []byte {
+	i := 0
+	if i < len(s) && (s[i] == '-' || s[i] == '+' || s[i] == ' ') {
+		i++
+	}
+	j := i
+	for j < len(s) && '0' <= s[j] && s[j] <= '9' {
+		j++
+	}
+	if i == j {
+		return append(b, s...)
+	}
+
+	b = append(b, s[:i]...)
+	for k := i; k < j; k++ {
+		if k != i && (j-k)%3 == 0 {
+			b = append(b, loc.group...)
+		}
+		b = append(b, s[k])
+	}
+
+	if j < len(s) && s[j] == '.' {
+		b = append(b, loc.decimal...)
+		j++
+	}
+	return append(b, s[j:]...)
+}
+
+// localNumber is a number made by percent and decimal filters, written in the locale on printing.
+type localNumber struct {
+	s       string // like -1234.5
+	percent bool
+}
+
+// String returns n in en.
+func (n localNumber) String() string {
+	return string(n.appendTo(nil, numberLocales["en"]))
+}
+
+func (n localNumber) appendTo(b []byte, loc numberLocale) []byte {
+	b = loc.localize(b, n.s)
+	if n.percent {
+		b = append(b, loc.percent...)
+	}
+	return b
+}
+
+// appendGrouped appends v formatted according to o with the `,` flag.
+//
+// width is the width of the field, and a left aligns the number.
+func appendGrouped(b []byte, o *op, v any, star []any, width int, loc numberLocale) []byte {
+	s := loc.localize(nil, fmt.Sprintf(o.verb, append(star, v)...))
+
+	pad := width - utf8.RuneCount(s)
+	if !o.left {
+		b = appendSpaces(b, pad)
+	}
+	b = append(b, s...)
+	if o.left {
+		b = appendSpaces(b, pad)
+	}
+	return b
+}
+
+func appendSpaces(b []byte, n int) []byte {
+	for ; n > 0; n-- {
+		b = append(b, ' ')
+	}
+	return b
+}
+
+// parsePrec parses an optional precision arg of a filter.
+func parsePrec(args []string, def int) (int, error) {
+	if len(args) == 0 {
+		return def, nil
+	}
+	prec, err := strconv.Atoi(args[0])
+	if err != nil || prec < 0 {
+		return 0, fmt.Errorf("invalid precision %q", args[0])
+	}
+	return prec, nil
+}
+
+// formatNumber formats an integer or a float in prec digits after the point (-1 for as needed).
+func formatNumber(v any, prec int, scale float64) (string, error) {
+	// integers are exact without scaling
+	var s string
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if scale != 1 {
+			return strconv.FormatFloat(float64(rv.Int())*scale, 'f', prec, 64), nil
+		}
+		s = strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if scale != 1 {
+			return strconv.FormatFloat(float64(rv.Uint())*scale, 'f', prec, 64), nil
+		}
+		s = strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32:
+		// as float32, not 0.10000000149011612
+		return strconv.FormatFloat(rv.Float()*scale, 'f', prec, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(rv.Float()*scale, 'f', prec, 64), nil
+	default:
+		return "", fmt.Errorf("not a number: %T", v)
+	}
+
+	if prec > 0 {
+		s += "." + strings.Repeat("0", prec)
+	}
+	return s, nil
+}
+
+// filterPercent formats a ratio as a percentage with args[0] digits after the point (defaults to 0).
+func filterPercent(v any, args ...string) (any, error) {
+	prec, err := parsePrec(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	s, err := formatNumber(v, prec, 100)
+	if err != nil {
+		return nil, err
+	}
+	return localNumber{s: s, percent: true}, nil
+}
+
+// filterDecimal formats a number grouped, with args[0] digits after the point (defaults to as needed).
+func filterDecimal(v any, args ...string) (any, error) {
+	prec, err := parsePrec(args, -1)
+	if err != nil {
+		return nil, err
+	}
+	s, err := formatNumber(v, prec, 1)
+	if err != nil {
+		return nil, err
+	}
+	return localNumber{s: s}, nil
+}
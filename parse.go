@@ -0,0 +1,666 @@
+package nmfmt
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token is either a literal or a placeholder in a format.
+type token struct {
+	lit string       // literal text (ph == nil)
+	ph  *placeholder // a placeholder
+}
+
+// placeholder is a parsed $name or ${name}.
+type placeholder struct {
+	pos     int    // byte offset of `$` in the format
+	raw     string // the placeholder as written
+	sect    byte   // `?`, `^`, `#` or `/` for a section, otherwise 0
+	sep     string // separator of a repeating section
+	eq      bool   // debug notation ($=name)
+	root    string
+	path    []segment
+	alts    []operand    // fallbacks following `|`
+	filters []filterCall // filters following fallbacks
+	spec    verbSpec     // spec.verb is 0 if omitted
+}
+
+// operand is a fallback, either a path or a literal.
+type operand struct {
+	arg   int // index of cachenode.argsOrder, set on compilation
+	root  string
+	path  []segment
+	lit   any // a string, an int or a float64
+	isLit bool
+}
+
+// name returns the canonical name like order.Items[0].
+func (p *placeholder) name() string {
+	return joinPath(p.root, p.path)
+}
+
+type segmentKind int
+
+const (
+	segField segmentKind = iota // .Name
+	segKey                      // ["Name"]
+	segIndex                    // [0]
+)
+
+// segment is a step of a path following the name.
+type segment struct {
+	kind  segmentKind
+	key   string // segField, segKey
+	index int    // segIndex
+}
+
+func (s segment) String() string {
+	switch s.kind {
+	case segField:
+		return "." + s.key
+	case segKey:
+		return "[" + strconv.Quote(s.key) + "]"
+	default:
+		return "[" + strconv.Itoa(s.index) + "]"
+	}
+}
+
+func joinPath(root string, path []segment) string {
+	if len(path) == 0 {
+		return root
+	}
+
+	var sb strings.Builder
+	sb.WriteString(root)
+	for _, s := range path {
+		sb.WriteString(s.String())
+	}
+	return sb.String()
+}
+
+// parse splits format into tokens.
+//
+// A malformed placeholder is kept as a literal, and the first one is reported as a *SyntaxError.
+// Names in filters are recognized as filters. If filters is nil, every name is, to find names of args
+// in a format for any Formatter.
+func parse(format string, filters map[string]filter) ([]token, error) {
+	var toks []token
+	var firstErr error
+
+	lit := 0 // start of the pending literal
+	i := 0
+	for {
+		j := strings.IndexByte(format[i:], '$')
+		if j == -1 {
+			break
+		}
+		i += j
+
+		// $$ is a literal $
+		if i+1 < len(format) && format[i+1] == '$' {
+			toks = append(toks, token{lit: format[lit : i+1]})
+			i += 2
+			lit = i
+			continue
+		}
+
+		ph, err := parsePlaceholder(format, i, filters)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if ph == nil {
+			i++
+			continue
+		}
+
+		if lit < i {
+			toks = append(toks, token{lit: format[lit:i]})
+		}
+		toks = append(toks, token{ph: ph})
+		i += len(ph.raw)
+		lit = i
+	}
+	if lit < len(format) {
+		toks = append(toks, token{lit: format[lit:]})
+	}
+
+	return toks, firstErr
+}
+
+// parsePlaceholder parses a placeholder at format[pos] (`$`).
+//
+// It returns nil if format[pos] does not start a placeholder.
+func parsePlaceholder(format string, pos int, filters map[string]filter) (*placeholder, error) {
+	s := &scanner{src: format, pos: pos + 1, filters: filters}
+	if s.peek() == '{' {
+		s.pos++
+		return s.braced(pos)
+	}
+	return s.short(pos), nil
+}
+
+type scanner struct {
+	src     string
+	pos     int
+	filters map[string]filter
+}
+
+func (s *scanner) peek() byte {
+	if s.pos >= len(s.src) {
+		return 0
+	}
+	return s.src[s.pos]
+}
+
+func (s *scanner) skipSpaces() {
+	for s.pos < len(s.src) && (s.src[s.pos] == ' ' || s.src[s.pos] == '\t') {
+		s.pos++
+	}
+}
+
+func (s *scanner) errorf(pos int, msg string) error {
+	return &SyntaxError{Format: s.src, Offset: pos, Msg: msg}
+}
+
+// short parses $name, $name.field, $name[0] and $name:verb.
+func (s *scanner) short(pos int) *placeholder {
+	ph := &placeholder{pos: pos}
+
+	if s.peek() == '=' {
+		ph.eq = true
+		s.pos++
+	}
+
+	// @index and so on in a repeating section
+	at := s.peek() == '@'
+	if at {
+		s.pos++
+	}
+	ph.root = s.word()
+	if ph.root == "" {
+		return nil
+	}
+	if at {
+		ph.root = s.src[s.pos-len(ph.root)-1 : s.pos]
+	}
+	ph.path = s.shortPath()
+
+	for s.peek() == '|' {
+		start := s.pos
+		s.pos++
+
+		// filters without args
+		if name, f, ok := s.filterName(); ok && f.minArgs == 0 {
+			ph.filters = append(ph.filters, filterCall{name: name, fn: f.fn})
+			continue
+		}
+		if len(ph.filters) == 0 && isOperandStart(s.peek()) {
+			if alt, err := s.operand(false); err == nil {
+				ph.alts = append(ph.alts, alt)
+				continue
+			}
+		}
+
+		// the rest is literal
+		s.pos = start
+		break
+	}
+
+	if s.peek() == ':' {
+		start := s.pos
+		s.pos++
+
+		// flags, width, precision and a verb without spaces nor `*`
+		for c := s.peek(); c != 0 && strings.IndexByte("-+#0,", c) != -1; c = s.peek() {
+			s.pos++
+		}
+		s.digits()
+		if s.peek() == '.' {
+			s.pos++
+			s.digits()
+		}
+		if c := s.peek(); c != 0 && strings.IndexByte(fmtVerbs, c) != -1 {
+			s.pos++
+			ph.spec, _ = parseSpec(s.src[start+1 : s.pos])
+		} else {
+			s.pos = start
+		}
+	}
+
+	ph.raw = s.src[pos:s.pos]
+	return ph
+}
+
+// braced parses ${name}, ${ name.field[0] : verb } and so on.
+func (s *scanner) braced(pos int) (*placeholder, error) {
+	if !strings.Contains(s.src[s.pos:], "}") {
+		return nil, s.errorf(pos, "unclosed placeholder")
+	}
+
+	ph := &placeholder{pos: pos}
+
+	s.skipSpaces()
+	if c := s.peek(); c == '?' || c == '^' || c == '#' || c == '/' {
+		return s.section(ph)
+	}
+	if s.peek() == '=' {
+		ph.eq = true
+		s.pos++
+		s.skipSpaces()
+	}
+
+	ph.root = s.bracedName()
+	if ph.root == "" {
+		if c := s.peek(); c == '}' || c == ':' || c == '|' || c == 0 {
+			return nil, s.errorf(pos, "empty name")
+		}
+		return nil, s.errorf(s.pos, "unexpected "+strconv.QuoteRune(rune(s.peek()))+" in placeholder")
+	}
+
+	var err error
+	if ph.path, err = s.bracedPath(); err != nil {
+		return nil, err
+	}
+
+	for s.peek() == '|' {
+		s.pos++
+		s.skipSpaces()
+		start := s.pos
+
+		if isOperandStart(s.peek()) {
+			if len(ph.filters) != 0 {
+				return nil, s.errorf(start, "fallback after filters")
+			}
+			alt, err := s.operand(true)
+			if _, ok := err.(*SyntaxError); ok {
+				return nil, err
+			} else if err != nil {
+				return nil, s.errorf(start, err.Error())
+			}
+			ph.alts = append(ph.alts, alt)
+			s.skipSpaces()
+			continue
+		}
+
+		name := s.word()
+		if name == "" {
+			return nil, s.errorf(start, "empty filter name")
+		}
+		f, found := s.filter(name)
+		if !found {
+			msg := "unknown filter " + strconv.Quote(name)
+			if len(ph.filters) == 0 && s.peek() != ':' {
+				// ${nick|name} of earlier versions
+				msg += " (a fallback name needs `$`)"
+			}
+			return nil, s.errorf(start, msg)
+		}
+		call, err := s.filterArgs(name, f)
+		if err != nil {
+			return nil, err
+		}
+		ph.filters = append(ph.filters, call)
+		s.skipSpaces()
+	}
+
+	if s.peek() == ':' {
+		s.pos++
+		end := strings.IndexByte(s.src[s.pos:], '}')
+		if end == -1 {
+			return nil, s.errorf(pos, "unclosed placeholder")
+		}
+		spec, err := parseSpec(s.src[s.pos : s.pos+end])
+		if err != nil {
+			return nil, s.errorf(s.pos, err.Error())
+		}
+		ph.spec = spec
+		s.pos += end
+	}
+
+	if s.peek() != '}' {
+		if s.peek() == 0 {
+			return nil, s.errorf(pos, "unclosed placeholder")
+		}
+		return nil, s.errorf(s.pos, "unexpected "+strconv.QuoteRune(rune(s.peek()))+" in placeholder")
+	}
+	s.pos++
+
+	ph.raw = s.src[pos:s.pos]
+	return ph, nil
+}
+
+// section parses ${?name}, ${^name}, ${#name sep=", "} or ${/name}.
+func (s *scanner) section(ph *placeholder) (*placeholder, error) {
+	ph.sect = s.peek()
+	s.pos++
+	s.skipSpaces()
+
+	if ph.root = s.bracedName(); ph.root == "" {
+		return nil, s.errorf(ph.pos, "empty name")
+	}
+	var err error
+	if ph.path, err = s.bracedPath(); err != nil {
+		return nil, err
+	}
+
+	if ph.sect == '#' {
+		s.skipSpaces()
+		if strings.HasPrefix(s.src[s.pos:], "sep=") {
+			s.pos += len("sep=")
+			start := s.pos
+			if s.peek() != '"' {
+				return nil, s.errorf(start, "separator must be a quoted string")
+			}
+			if ph.sep, err = s.quoted(); err != nil {
+				return nil, s.errorf(start, err.Error())
+			}
+			s.skipSpaces()
+		}
+	}
+
+	if s.peek() != '}' {
+		return nil, s.errorf(s.pos, "unexpected "+strconv.QuoteRune(rune(s.peek()))+" in section")
+	}
+	s.pos++
+
+	ph.raw = s.src[ph.pos:s.pos]
+	return ph, nil
+}
+
+// shortPath parses segments following a name in $name.
+func (s *scanner) shortPath() []segment {
+	var path []segment
+	for {
+		start := s.pos
+		if s.peek() == '.' {
+			s.pos++
+			if name := s.word(); name != "" {
+				path = append(path, segment{kind: segField, key: name})
+				continue
+			}
+		} else if s.peek() == '[' {
+			s.pos++
+			if seg, err := s.subscript(); err == nil {
+				path = append(path, seg)
+				continue
+			}
+		}
+		s.pos = start
+		return path
+	}
+}
+
+// bracedPath parses segments following a name in ${name}.
+func (s *scanner) bracedPath() ([]segment, error) {
+	var path []segment
+	for {
+		s.skipSpaces()
+		if s.peek() == '.' {
+			s.pos++
+			s.skipSpaces()
+			name := s.bracedName()
+			if name == "" {
+				return nil, s.errorf(s.pos, "empty field name")
+			}
+			path = append(path, segment{kind: segField, key: name})
+		} else if s.peek() == '[' {
+			start := s.pos
+			s.pos++
+			seg, err := s.subscript()
+			if err != nil {
+				return nil, s.errorf(start, err.Error())
+			}
+			path = append(path, seg)
+		} else {
+			return path, nil
+		}
+	}
+}
+
+// isOperandStart reports whether c starts a fallback rather than a filter.
+func isOperandStart(c byte) bool {
+	return c == '$' || c == '"' || c == '-' || '0' <= c && c <= '9'
+}
+
+// operand parses a fallback after `|`: a path marked by `$`, a quoted string or a number.
+func (s *scanner) operand(braced bool) (operand, error) {
+	c := s.peek()
+
+	switch {
+	case c == '"':
+		lit, err := s.quoted()
+		if err != nil {
+			return operand{}, err
+		}
+		return operand{lit: lit, isLit: true}, nil
+
+	case c == '-' || '0' <= c && c <= '9':
+		start := s.pos
+		s.pos++
+		for c := s.peek(); '0' <= c && c <= '9' || c == '.' && s.pos+1 < len(s.src) && '0' <= s.src[s.pos+1] && s.src[s.pos+1] <= '9'; c = s.peek() {
+			s.pos++
+		}
+		num := s.src[start:s.pos]
+		if i, err := strconv.Atoi(num); err == nil {
+			return operand{lit: i, isLit: true}, nil
+		}
+		if f, err := strconv.ParseFloat(num, 64); err == nil {
+			return operand{lit: f, isLit: true}, nil
+		}
+		return operand{}, errorString("invalid number")
+
+	case c != '$':
+		return operand{}, errorString("fallback must be $name, a quoted string or a number")
+	}
+	s.pos++
+
+	if !braced {
+		root := s.word()
+		if root == "" {
+			return operand{}, errorString("empty name")
+		}
+		return operand{root: root, path: s.shortPath()}, nil
+	}
+
+	root := s.bracedName()
+	if root == "" {
+		return operand{}, errorString("empty name")
+	}
+	path, err := s.bracedPath()
+	if err != nil {
+		return operand{}, err
+	}
+	return operand{root: root, path: path}, nil
+}
+
+// filterName reads a name of a filter.
+//
+// It reads nothing if the name is not of a filter, or is followed by a path.
+func (s *scanner) filterName() (string, filter, bool) {
+	start := s.pos
+	name := s.word()
+	f, found := s.filter(name)
+	if !found || s.peek() == '.' || s.peek() == '[' {
+		s.pos = start
+		return name, filter{}, false
+	}
+	return name, f, true
+}
+
+// filter returns the filter of name.
+//
+// Without filters, any name is a filter taking any args.
+func (s *scanner) filter(name string) (filter, bool) {
+	if s.filters == nil {
+		return filter{maxArgs: -1}, name != ""
+	}
+	f, found := s.filters[name]
+	return f, found
+}
+
+// filterArgs parses `:arg` following a filter name in ${}.
+//
+// `:verb` at the end is left if it is not an arg.
+func (s *scanner) filterArgs(name string, f filter) (filterCall, error) {
+	call := filterCall{name: name, fn: f.fn}
+
+	for {
+		s.skipSpaces()
+		if s.peek() != ':' {
+			break
+		}
+		colon := s.pos
+		s.pos++
+
+		// the rest may be the verb of the placeholder
+		if len(call.args) >= f.minArgs {
+			if end := strings.IndexByte(s.src[s.pos:], '}'); end != -1 {
+				rest := s.src[s.pos : s.pos+end]
+				_, err := parseSpec(rest)
+				full := f.maxArgs != -1 && len(call.args) >= f.maxArgs
+				if !strings.ContainsAny(rest, `"|:`) && (err == nil || full) {
+					s.pos = colon
+					break
+				}
+			}
+		}
+		if f.maxArgs != -1 && len(call.args) >= f.maxArgs {
+			return call, s.errorf(colon, "too many args for filter "+strconv.Quote(name))
+		}
+
+		s.skipSpaces()
+		start := s.pos
+		arg, err := s.filterArg()
+		if err != nil {
+			return call, s.errorf(start, err.Error())
+		}
+		call.args = append(call.args, arg)
+	}
+
+	if len(call.args) < f.minArgs {
+		return call, s.errorf(s.pos, "too few args for filter "+strconv.Quote(name))
+	}
+
+	return call, nil
+}
+
+// filterArg reads a quoted string, or characters until one of `:|}` or a space.
+//
+// A quoted string may follow `key=` like admin="Administrator".
+func (s *scanner) filterArg() (string, error) {
+	if s.peek() == '"' {
+		return s.quoted()
+	}
+
+	start := s.pos
+	for c := s.peek(); c != 0 && strings.IndexByte(":|} \t", c) == -1; c = s.peek() {
+		s.pos++
+		if c == '=' && s.peek() == '"' {
+			key := s.src[start:s.pos]
+			lit, err := s.quoted()
+			if err != nil {
+				return "", err
+			}
+			return key + lit, nil
+		}
+	}
+	if s.pos == start {
+		return "", errorString("empty filter arg")
+	}
+	return s.src[start:s.pos], nil
+}
+
+// subscript parses [0], [-1] or ["key"] after `[`.
+func (s *scanner) subscript() (segment, error) {
+	s.skipSpaces()
+
+	var seg segment
+	if s.peek() == '"' {
+		lit, err := s.quoted()
+		if err != nil {
+			return seg, err
+		}
+		seg = segment{kind: segKey, key: lit}
+	} else {
+		start := s.pos
+		if s.peek() == '-' {
+			s.pos++
+		}
+		for '0' <= s.peek() && s.peek() <= '9' {
+			s.pos++
+		}
+		idx, err := strconv.Atoi(s.src[start:s.pos])
+		if err != nil {
+			return seg, errorString("invalid subscript")
+		}
+		seg = segment{kind: segIndex, index: idx}
+	}
+
+	s.skipSpaces()
+	if s.peek() != ']' {
+		return seg, errorString("unclosed subscript")
+	}
+	s.pos++
+
+	return seg, nil
+}
+
+// quoted parses a Go style double quoted string.
+func (s *scanner) quoted() (string, error) {
+	start := s.pos
+	for i := s.pos + 1; i < len(s.src); i++ {
+		switch s.src[i] {
+		case '\\':
+			i++
+		case '"':
+			lit, err := strconv.Unquote(s.src[start : i+1])
+			if err != nil {
+				return "", errorString("invalid string literal")
+			}
+			s.pos = i + 1
+			return lit, nil
+		}
+	}
+	return "", errorString("unclosed string literal")
+}
+
+// word reads letters, digits and `_`, including non-ASCII ones.
+func (s *scanner) word() string {
+	start := s.pos
+	for s.pos < len(s.src) {
+		r, size := rune(s.src[s.pos]), 1
+		if r >= utf8.RuneSelf {
+			r, size = utf8.DecodeRuneInString(s.src[s.pos:])
+		}
+		if !isWordRune(r) {
+			break
+		}
+		s.pos += size
+	}
+	return s.src[start:s.pos]
+}
+
+// bracedName reads a name in ${}, which may contain any characters but delimiters.
+func (s *scanner) bracedName() string {
+	start := s.pos
+	for s.pos < len(s.src) && !strings.ContainsRune(" \t.[]:{}|\"$", rune(s.src[s.pos])) {
+		s.pos++
+	}
+	return s.src[start:s.pos]
+}
+
+func isWordRune(r rune) bool {
+	if r < utf8.RuneSelf {
+		return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_'
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// errorString is a message to be wrapped into a *SyntaxError.
+type errorString string
+
+func (e errorString) Error() string {
+	return string(e)
+}
@@ -0,0 +1,510 @@
+package nmfmt
+
+import (
+	"cmp"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// op is a unit of rendering, either a literal, a value or a section.
+type op struct {
+	lit string // literal text (arg == -1)
+
+	sect byte   // `?`, `^` or `#` for a section
+	sep  string // separator of a repeating section
+	body []op   // ops in a section
+
+	raw     string    // the placeholder as written
+	eq      string    // `name=` of the debug notation
+	arg     int       // index of cachenode.argsOrder
+	path    []segment // steps following the name
+	alts    []operand // fallbacks
+	filters []filterCall
+	verb    string // fmt style verb like "%v", "%+8.2f" or "%*.*f"
+	char    byte   // the verb character if verb has no flags, width nor precision, otherwise 0
+	simple  bool   // a value of the name as it is, without sections, paths, fallbacks, filters nor `*`
+	wrap    bool   // `w` verb (Errorf)
+	wverb   string // verb with `w`, for a value not an error
+
+	group bool // `,` flag, with verb without the width
+	width int  // width of the `,` flag
+	left  bool // `-` flag of the `,` flag
+
+	widthArg int // index of cachenode.argsOrder for `*`, or -1
+	precArg  int // index of cachenode.argsOrder for `.*`, or -1
+}
+
+func newValueOp(arg int, path []segment, spec verbSpec) op {
+	o := op{arg: arg, path: path, widthArg: -1, precArg: -1}
+
+	// `w` (Errorf) is printed as `v` if the value is an error
+	if spec.verb == 'w' {
+		o.wverb = spec.String()
+		spec.verb = 'v'
+		o.wrap = true
+	}
+
+	// grouped digits are padded after being localized
+	if spec.group {
+		o.group = true
+		o.width = spec.width
+		o.left = strings.IndexByte(spec.flags, '-') != -1
+		spec.flags = strings.NewReplacer("-", "", "0", "").Replace(spec.flags)
+		spec.hasWidth, spec.widthArg = false, ""
+		o.verb = spec.String()
+		return o
+	}
+
+	o.verb = spec.String()
+
+	if len(o.verb) == 2 {
+		o.char = spec.verb
+	}
+
+	return o
+}
+
+// render appends the result of c to b.
+//
+// vals are the values of c.argsOrder.
+// Placeholders without values are printed according to opts.missingKey.
+// Errors of `w` verbs are returned as wrapped.
+func (c *cachenode) render(b []byte, vals []any, opts *formatterOptions) (_ []byte, wrapped []error, _ error) {
+	if c.simple {
+		if sb, ok := c.renderSimple(b, vals); ok {
+			return sb, nil, nil
+		}
+	}
+
+	r := renderer{c: c, vals: vals, missing: opts.missingKey, numbers: &opts.numbers}
+
+	b, err := r.render(b, c.ops)
+	if err != nil {
+		return b, nil, err
+	}
+
+	if len(r.missingNames) != 0 {
+		return b, nil, &MissingKeyError{Names: r.missingNames}
+	}
+	return b, r.wrapped, nil
+}
+
+// renderSimple appends the result of c of only simple ops to b.
+//
+// It fails on a value not found, which is up to opts.missingKey.
+func (c *cachenode) renderSimple(b []byte, vals []any) ([]byte, bool) {
+	n := len(b)
+	for i := 0; i < len(c.ops); i++ {
+		o := &c.ops[i]
+		if o.arg == -1 {
+			b = append(b, o.lit...)
+			continue
+		}
+
+		v := vals[o.arg]
+		if v == (absentArg{}) {
+			return b[:n], false
+		}
+		b = append(b, o.eq...)
+		b = appendValue(b, o, v)
+	}
+	return b, true
+}
+
+// renderer holds states while rendering a cachenode.
+type renderer struct {
+	c       *cachenode
+	vals    []any
+	missing MissingKeyPolicy
+	numbers *numberLocale
+
+	missingNames []string
+	wrapped      []error
+
+	scopes []scope // repeating sections being rendered, innermost last
+}
+
+// scope is an element of a repeating section.
+type scope struct {
+	elem  any
+	key   any // for a map
+	index int
+	n     int
+}
+
+func (r *renderer) addMissing(name string) {
+	if !slices.Contains(r.missingNames, name) {
+		r.missingNames = append(r.missingNames, name)
+	}
+}
+
+func (r *renderer) render(b []byte, ops []op) ([]byte, error) {
+	c := r.c
+
+	for i := 0; i < len(ops); i++ {
+		o := &ops[i]
+		if o.arg == -1 {
+			b = append(b, o.lit...)
+			continue
+		}
+		if o.simple && len(r.scopes) == 0 {
+			if v := r.vals[o.arg]; v != (absentArg{}) {
+				b = append(b, o.eq...)
+				b = appendValue(b, o, v)
+				continue
+			}
+		}
+
+		if o.sect == '#' {
+			v, err := r.resolve(o.arg, o.path)
+			if err != nil {
+				continue
+			}
+			if b, err = r.loop(b, o, v); err != nil {
+				return b, err
+			}
+			continue
+		}
+		if o.sect != 0 {
+			v, err := r.resolve(o.arg, o.path)
+			if (err == nil && truthy(v)) == (o.sect == '?') {
+				if b, err = r.render(b, o.body); err != nil {
+					return b, err
+				}
+			}
+			continue
+		}
+
+		v, err := r.value(o)
+		if err != nil {
+			switch r.missing {
+			case MissingEmpty:
+				b = append(b, o.eq...)
+			case MissingKeep:
+				b = append(b, o.raw...)
+			case MissingError:
+				r.addMissing(joinPath(c.argsOrder[o.arg], o.path))
+			default:
+				b = append(b, o.eq...)
+				if e := err.(*pathError); e.kind != "MISSING" || e.path != c.argsOrder[o.arg] {
+					b = appendMarker(b, o, e)
+				} else { // a name not found
+					b = appendValue(b, o, nil)
+				}
+			}
+			continue
+		}
+
+		for j := 0; j < len(o.filters); j++ {
+			fc := &o.filters[j]
+			if v, err = fc.fn(v, fc.args...); err != nil {
+				return b, &FilterError{Name: joinPath(c.argsOrder[o.arg], o.path), Filter: fc.name, Err: err}
+			}
+		}
+
+		if o.wrap {
+			err, ok := v.(error)
+			if !ok {
+				// printed by fmt like %!w(int=5)
+				b = append(b, o.eq...)
+				b = fmt.Appendf(b, o.wverb, append(r.stars(o), v)...)
+				continue
+			}
+			r.wrapped = append(r.wrapped, err)
+		}
+
+		if n, ok := v.(localNumber); ok {
+			v = string(n.appendTo(nil, *r.numbers))
+		}
+
+		b = append(b, o.eq...)
+		if o.group {
+			width := o.width
+			if o.widthArg != -1 {
+				if n, ok := r.starArg(o.widthArg).(int); ok {
+					width = n
+				}
+			}
+			var star []any
+			if o.precArg != -1 {
+				star = append(star, r.starArg(o.precArg))
+			}
+			b = appendGrouped(b, o, v, star, width, *r.numbers)
+			continue
+		}
+		if o.widthArg == -1 && o.precArg == -1 {
+			b = appendValue(b, o, v)
+			continue
+		}
+
+		b = fmt.Appendf(b, o.verb, append(r.stars(o), v)...)
+	}
+
+	return b, nil
+}
+
+// stars returns the values of `*` of o, followed by room for the value.
+func (r *renderer) stars(o *op) []any {
+	if o.widthArg == -1 && o.precArg == -1 {
+		return nil
+	}
+
+	// `*` takes an int arg
+	star := make([]any, 0, 3)
+	for _, arg := range []int{o.widthArg, o.precArg} {
+		if arg != -1 {
+			star = append(star, r.starArg(arg))
+		}
+	}
+	return star
+}
+
+// starArg returns the value of `*name` as an int if possible.
+func (r *renderer) starArg(arg int) any {
+	v, err := r.resolve(arg, nil)
+	if err != nil {
+		v = absentArg{}
+	}
+	n, ok := toInt(v)
+	if !ok && r.missing == MissingError {
+		r.addMissing(r.c.argsOrder[arg])
+	}
+	return n
+}
+
+// loop renders the body of o for each element of v.
+//
+// Maps are iterated in order of their keys. Values other than slices, arrays and maps print nothing.
+func (r *renderer) loop(b []byte, o *op, v any) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+
+	var keys []reflect.Value
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+	case reflect.Map:
+		keys = rv.MapKeys()
+		slices.SortFunc(keys, compareKeys)
+	default:
+		return b, nil
+	}
+
+	n := rv.Len()
+	r.scopes = append(r.scopes, scope{n: n})
+	depth := len(r.scopes)
+
+	var err error
+	for i := 0; i < n; i++ {
+		if i != 0 {
+			b = append(b, o.sep...)
+		}
+
+		sc := &r.scopes[depth-1]
+		sc.index = i
+		if keys != nil {
+			sc.key = keys[i].Interface()
+			sc.elem = rv.MapIndex(keys[i]).Interface()
+		} else {
+			sc.elem = rv.Index(i).Interface()
+		}
+
+		if b, err = r.render(b, o.body); err != nil {
+			break
+		}
+	}
+
+	r.scopes = r.scopes[:depth-1]
+	return b, err
+}
+
+// compareKeys orders keys of a map.
+func compareKeys(a, b reflect.Value) int {
+	switch a.Kind() {
+	case reflect.String:
+		return cmp.Compare(a.String(), b.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float())
+	}
+	return cmp.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// value returns the value of o, or the first fallback found and not nil.
+func (r *renderer) value(o *op) (any, error) {
+	v, err := r.resolve(o.arg, o.path)
+	if err == nil && v != nil || len(o.alts) == 0 {
+		return v, err
+	}
+
+	for i := 0; i < len(o.alts); i++ {
+		alt := &o.alts[i]
+		if alt.isLit {
+			return alt.lit, nil
+		}
+		if av, aerr := r.resolve(alt.arg, alt.path); aerr == nil && av != nil {
+			return av, nil
+		}
+	}
+
+	return v, err
+}
+
+// resolve returns the value of a path.
+//
+// In repeating sections, the name is looked up in the elements from the innermost one, and then in args.
+func (r *renderer) resolve(arg int, path []segment) (any, error) {
+	var v any
+	found := false
+	if len(r.scopes) != 0 {
+		v, found = r.scoped(r.c.argsOrder[arg])
+	}
+	if !found {
+		v = r.vals[arg]
+		if _, absent := v.(absentArg); absent {
+			return nil, &pathError{path: r.c.argsOrder[arg], kind: "MISSING"}
+		}
+	}
+	if len(path) == 0 {
+		return v, nil
+	}
+	return walk(v, r.c.argsOrder[arg], path)
+}
+
+// scoped returns the value of name in the elements of repeating sections.
+func (r *renderer) scoped(name string) (any, bool) {
+	if name[0] == '@' {
+		sc := &r.scopes[len(r.scopes)-1]
+		switch name {
+		case "@index":
+			return sc.index, true
+		case "@first":
+			return sc.index == 0, true
+		case "@last":
+			return sc.index == sc.n-1, true
+		case "@key":
+			return sc.key, sc.key != nil
+		case "@value":
+			return sc.elem, true
+		}
+		return nil, false
+	}
+
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if v, err := walk(r.scopes[i].elem, "", []segment{{kind: segField, key: name}}); err == nil {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// appendMarker appends a fmt style marker like %!v(NILPTR=user.Address).
+func appendMarker(b []byte, o *op, e *pathError) []byte {
+	b = append(b, "%!"...)
+	b = append(b, o.verb[len(o.verb)-1])
+	b = append(b, '(')
+	b = append(b, e.kind...)
+	b = append(b, '=')
+	b = append(b, e.path...)
+	return append(b, ')')
+}
+
+// appendValue appends v formatted according to o.
+//
+// Values of common types are formatted without fmt.
+func appendValue(b []byte, o *op, v any) []byte {
+	switch o.char {
+	case 'v':
+		switch v := v.(type) {
+		case nil:
+			return append(b, "<nil>"...)
+		case string:
+			return append(b, v...)
+		case bool:
+			return strconv.AppendBool(b, v)
+		case int:
+			return strconv.AppendInt(b, int64(v), 10)
+		case int64:
+			return strconv.AppendInt(b, v, 10)
+		case int32:
+			return strconv.AppendInt(b, int64(v), 10)
+		case uint:
+			return strconv.AppendUint(b, uint64(v), 10)
+		case uint64:
+			return strconv.AppendUint(b, v, 10)
+		}
+
+	case 's':
+		if s, ok := v.(string); ok {
+			return append(b, s...)
+		}
+
+	case 'd':
+		switch v := v.(type) {
+		case int:
+			return strconv.AppendInt(b, int64(v), 10)
+		case int64:
+			return strconv.AppendInt(b, v, 10)
+		case int32:
+			return strconv.AppendInt(b, int64(v), 10)
+		case uint:
+			return strconv.AppendUint(b, uint64(v), 10)
+		case uint64:
+			return strconv.AppendUint(b, v, 10)
+		}
+
+	case 'q':
+		if s, ok := v.(string); ok {
+			return strconv.AppendQuote(b, s)
+		}
+	}
+
+	return fmt.Appendf(b, o.verb, v)
+}
+
+// toInt converts v of an integer type into an int.
+//
+// A value of other types is returned as it is, so that fmt reports %!(BADWIDTH) or %!(BADPREC).
+func toInt(v any) (any, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int(rv.Uint()), true
+	}
+	if _, absent := v.(absentArg); absent {
+		return nil, false
+	}
+	return v, false
+}
+
+// truthy reports whether v is neither absent, nil, false, zero nor empty.
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Func, reflect.Chan:
+		return !rv.IsNil()
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return rv.Len() != 0
+	case reflect.Struct:
+		return true
+	}
+	return !rv.IsZero()
+}
@@ -0,0 +1,223 @@
+// Package slogfmt provides a slog.Handler that formats messages by nmfmt with attrs of records.
+//
+//	logger := slog.New(slogfmt.NewHandler(slog.NewJSONHandler(os.Stderr, nil), nil))
+//	logger.Info("user $user logged in from $req.ip", "user", "kim", slog.Group("req", "ip", "192.0.2.1"))
+//	// {"time":...,"level":"INFO","msg":"user kim logged in from 192.0.2.1","user":"kim","req":{"ip":"192.0.2.1"}}
+package slogfmt
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/shu-go/nmfmt"
+)
+
+// bufPool holds buffers of formatted messages.
+var bufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 256)
+		return &b
+	},
+}
+
+// HandlerOptions are options for a Handler.
+type HandlerOptions struct {
+	// Formatter formats messages. Defaults to nmfmt.New().
+	Formatter *nmfmt.Formatter
+
+	// DropUsed drops attrs of a record that are referred in the message.
+	// $req.id drops only id from the group req, and $req drops the whole group.
+	// Attrs added by WithAttrs are always kept.
+	DropUsed bool
+}
+
+// Handler formats the message of a record as an nmfmt format, and passes the record to another handler.
+//
+// Names in the message are looked up in attrs of the record and of WithAttrs.
+// Attrs in groups are referred like $req.id.
+// A message that cannot be formatted is passed as it is, with an attr !BADMSG describing the error.
+type Handler struct {
+	h        slog.Handler
+	f        *nmfmt.Formatter
+	dropUsed bool
+
+	frames []frame // attrs of WithAttrs by groups, starting with the top level
+}
+
+// frame is attrs in a group.
+type frame struct {
+	group string
+	attrs []slog.Attr
+}
+
+// NewHandler returns a Handler passing records to h.
+//
+// opts may be nil.
+func NewHandler(h slog.Handler, opts *HandlerOptions) *Handler {
+	if opts == nil {
+		opts = &HandlerOptions{}
+	}
+
+	f := opts.Formatter
+	if f == nil {
+		nf := nmfmt.New()
+		f = &nf
+	}
+
+	return &Handler{
+		h:        h,
+		f:        f,
+		dropUsed: opts.DropUsed,
+		frames:   []frame{{}},
+	}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.h.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	msg := r.Message
+	var bad error
+	if strings.IndexByte(msg, '$') != -1 {
+		bp := bufPool.Get().(*[]byte)
+		buf, err := h.f.Appendf((*bp)[:0], msg, h.args(attrs))
+		if err == nil {
+			msg = string(buf)
+		} else {
+			bad = err
+		}
+		// large buffers are not worth keeping
+		if cap(buf) <= 64*1024 {
+			*bp = buf
+			bufPool.Put(bp)
+		}
+	}
+
+	if h.dropUsed && bad == nil {
+		attrs = h.dropUsedAttrs(r.Message, attrs)
+	}
+
+	nr := slog.NewRecord(r.Time, r.Level, msg, r.PC)
+	nr.AddAttrs(attrs...)
+	if bad != nil {
+		nr.AddAttrs(slog.String("!BADMSG", bad.Error()))
+	}
+	return h.h.Handle(ctx, nr)
+}
+
+// args returns attrs of WithAttrs and attrs of a record, in their groups.
+func (h *Handler) args(attrs []slog.Attr) []slog.Attr {
+	for i := len(h.frames) - 1; i >= 0; i-- {
+		fr := &h.frames[i]
+		attrs = append(slices.Clip(fr.attrs), attrs...)
+		if fr.group != "" {
+			attrs = []slog.Attr{{Key: fr.group, Value: slog.GroupValue(attrs...)}}
+		}
+	}
+	return attrs
+}
+
+// dropUsedAttrs returns attrs that are not referred in format.
+func (h *Handler) dropUsedAttrs(format string, attrs []slog.Attr) []slog.Attr {
+	paths := nmfmt.ExtractPaths(format)
+	if len(paths) == 0 {
+		return attrs
+	}
+
+	var prefix string
+	for _, fr := range h.frames[1:] {
+		prefix += fr.group + "."
+	}
+
+	return dropUsed(paths, prefix, attrs)
+}
+
+// dropUsed returns attrs whose paths, following prefix, are not in paths.
+//
+// A group referred in part is rebuilt without the referred attrs, and dropped if nothing is left.
+func dropUsed(paths map[string]struct{}, prefix string, attrs []slog.Attr) []slog.Attr {
+	kept := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		path := prefix + a.Key
+		switch {
+		case a.Key == "":
+			kept = append(kept, a)
+
+		case referred(paths, path):
+			// dropped
+
+		case within(paths, path):
+			v := a.Value.Resolve()
+			if v.Kind() != slog.KindGroup {
+				// a path in a value, like $req.header.Host
+				continue
+			}
+			if g := dropUsed(paths, path+".", v.Group()); len(g) != 0 {
+				kept = append(kept, slog.Attr{Key: a.Key, Value: slog.GroupValue(g...)})
+			}
+
+		default:
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// referred reports whether path, or the group having it, is one of paths.
+func referred(paths map[string]struct{}, path string) bool {
+	for p := range paths {
+		if p == path || strings.HasPrefix(path, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether a path in path is one of paths.
+func within(paths map[string]struct{}, path string) bool {
+	for p := range paths {
+		if len(p) > len(path) && strings.HasPrefix(p, path) && (p[len(path)] == '.' || p[len(path)] == '[') {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	h2 := h.clone()
+	last := &h2.frames[len(h2.frames)-1]
+	last.attrs = append(slices.Clip(last.attrs), attrs...)
+	h2.h = h.h.WithAttrs(attrs)
+	return h2
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	h2 := h.clone()
+	h2.frames = append(h2.frames, frame{group: name})
+	h2.h = h.h.WithGroup(name)
+	return h2
+}
+
+func (h *Handler) clone() *Handler {
+	h2 := *h
+	h2.frames = slices.Clone(h.frames)
+	return &h2
+}
@@ -0,0 +1,111 @@
+package slogfmt_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/shu-go/gotwant"
+	"github.com/shu-go/nmfmt"
+	"github.com/shu-go/nmfmt/slogfmt"
+)
+
+func newLogger(buf *bytes.Buffer, opts *slogfmt.HandlerOptions) *slog.Logger {
+	h := slog.NewTextHandler(buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	return slog.New(slogfmt.NewHandler(h, opts))
+}
+
+func Example() {
+	h := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	logger := slog.New(slogfmt.NewHandler(h, nil))
+
+	logger.Info("user $user logged in from $req.ip", "user", "kim", slog.Group("req", "ip", "192.0.2.1"))
+
+	// Output:
+	// {"level":"INFO","msg":"user kim logged in from 192.0.2.1","user":"kim","req":{"ip":"192.0.2.1"}}
+}
+
+func TestHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := newLogger(buf, nil)
+
+	logger.Info("user $user: ${count} ${count|plural:file:files}", "user", "kim", "count", 2)
+	gotwant.Test(t, buf.String(), `level=INFO msg="user kim: 2 files" user=kim count=2`+"\n")
+
+	buf.Reset()
+	logger.Info("no placeholders", "user", "kim")
+	gotwant.Test(t, buf.String(), `level=INFO msg="no placeholders" user=kim`+"\n")
+
+	t.Run("Group", func(t *testing.T) {
+		buf.Reset()
+		logger.Info("$req.method $req.path", slog.Group("req", "method", "GET", "path", "/"))
+		gotwant.Test(t, buf.String(), `level=INFO msg="GET /" req.method=GET req.path=/`+"\n")
+
+		buf.Reset()
+		logger.WithGroup("req").With("id", 7).Info("$req.id: $req.status", "status", 200)
+		gotwant.Test(t, buf.String(), `level=INFO msg="7: 200" req.id=7 req.status=200`+"\n")
+
+		buf.Reset()
+		logger.With("app", "x").WithGroup("req").Info("$app: $req", "id", 1)
+		gotwant.Test(t, buf.String(), `level=INFO msg="x: map[id:1]" app=x req.id=1`+"\n")
+	})
+
+	t.Run("DropUsed", func(t *testing.T) {
+		logger := newLogger(buf, &slogfmt.HandlerOptions{DropUsed: true})
+
+		buf.Reset()
+		logger.With("app", "x").Info("$app: user $user", "user", "kim", "count", 2)
+		gotwant.Test(t, buf.String(), `level=INFO msg="x: user kim" app=x count=2`+"\n")
+
+		buf.Reset()
+		logger.WithGroup("req").Info("$req.id", "id", 1, "status", 200)
+		gotwant.Test(t, buf.String(), `level=INFO msg=1 req.status=200`+"\n")
+
+		buf.Reset()
+		logger.Info("${req.id}", slog.Group("req", "id", 1, "status", 200), "ids", 2)
+		gotwant.Test(t, buf.String(), `level=INFO msg=1 req.status=200 ids=2`+"\n")
+
+		buf.Reset()
+		logger.Info("$req", slog.Group("req", "id", 1), "ids", 2)
+		gotwant.Test(t, buf.String(), `level=INFO msg=map[id:1] ids=2`+"\n")
+
+		buf.Reset()
+		logger.Info("$req.id $req.user.name", slog.Group("req", "id", 1, slog.Group("user", "name", "kim", "role", "admin")))
+		gotwant.Test(t, buf.String(), `level=INFO msg="1 kim" req.user.role=admin`+"\n")
+
+		buf.Reset()
+		logger.Info("$req.id", slog.Group("req", "id", 1))
+		gotwant.Test(t, buf.String(), `level=INFO msg=1`+"\n")
+	})
+
+	t.Run("BadMessage", func(t *testing.T) {
+		f := nmfmt.New(nmfmt.MissingKey(nmfmt.MissingError))
+		logger := newLogger(buf, &slogfmt.HandlerOptions{Formatter: &f, DropUsed: true})
+
+		buf.Reset()
+		logger.Info("user $user", "name", "kim")
+		gotwant.Test(t, buf.String(), `level=INFO msg="user $user" name=kim !BADMSG="nmfmt: missing keys: user"`+"\n")
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		h := slogfmt.NewHandler(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelWarn}), nil)
+		gotwant.Test(t, h.Enabled(context.Background(), slog.LevelInfo), false)
+		gotwant.Test(t, h.Enabled(context.Background(), slog.LevelError), true)
+	})
+}
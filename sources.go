@@ -0,0 +1,114 @@
+package nmfmt
+
+import (
+	"log/slog"
+	"reflect"
+)
+
+// Sources returns a Lookuper of srcs, where a name is looked up in order and the first source having it wins.
+//
+// A source is an M, a map with string keys, a struct or a pointer to a struct, a Lookuper, slog.Attr or []slog.Attr.
+// Other values are ignored.
+//
+//	nmfmt.Printf("$user: $path ($status)", nmfmt.Sources(reqM, user, defaults))
+func Sources(srcs ...any) Lookuper {
+	return f.Sources(srcs...)
+}
+
+// Sources is like the package function Sources, with names of struct fields converted by the Naming option of f.
+func (f *Formatter) Sources(srcs ...any) Lookuper {
+	return &sources{srcs: srcs, naming: f.opts.naming}
+}
+
+// sources is a Lookuper made by Sources.
+type sources struct {
+	srcs   []any
+	naming NamingStrategy
+}
+
+func (s *sources) Lookup(name string) (any, bool) {
+	for _, src := range s.srcs {
+		if v, found, _ := lookupSource(src, name, s.naming); found {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// eachName calls fn with the names of all sources, for reporting unused ones.
+func (s *sources) eachName(fn func(name string)) {
+	for _, src := range s.srcs {
+		eachSourceName(src, s.naming, fn)
+	}
+}
+
+// lookupSource returns the value of name in a source v.
+//
+// ok is false if v is not a source: an M, a map with string keys, a struct or a pointer to a struct,
+// a Lookuper, slog.Attr or []slog.Attr.
+func lookupSource(v any, name string, naming NamingStrategy) (val any, found, ok bool) {
+	switch s := v.(type) {
+	case string:
+		return nil, false, false
+	case M:
+		val, found = s[name]
+		return val, found, true
+	case map[string]any:
+		val, found = s[name]
+		return val, found, true
+	case slog.Attr:
+		val, found = findAttr([]slog.Attr{s}, name)
+		return val, found, true
+	case []slog.Attr:
+		val, found = findAttr(s, name)
+		return val, found, true
+	case Lookuper:
+		val, found = s.Lookup(name)
+		return val, found, true
+	}
+
+	if m, ok := newMapArg(v); ok {
+		val, found = m.lookup(name)
+		return val, found, true
+	}
+	if s, ok := newStructArg(v, naming); ok {
+		val, found = s.lookup(name)
+		return val, found, true
+	}
+	return nil, false, isNilStruct(v)
+}
+
+// eachSourceName calls fn with the names in a source v, and reports whether v is a source.
+//
+// Names of a Lookuper are unknown, except for those made by Sources.
+func eachSourceName(v any, naming NamingStrategy, fn func(name string)) bool {
+	switch s := v.(type) {
+	case string:
+		return false
+	case slog.Attr:
+		eachAttrKey([]slog.Attr{s}, fn)
+		return true
+	case []slog.Attr:
+		eachAttrKey(s, fn)
+		return true
+	case Lookuper:
+		lookuperArg{s}.eachName(fn)
+		return true
+	}
+
+	if m, ok := newMapArg(v); ok {
+		m.eachName(fn)
+		return true
+	}
+	if s, ok := newStructArg(v, naming); ok {
+		s.eachName(fn)
+		return true
+	}
+	return isNilStruct(v)
+}
+
+// isNilStruct reports whether v is a nil pointer to a struct, which is a source without names.
+func isNilStruct(v any) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Pointer && rv.IsNil() && rv.Type().Elem().Kind() == reflect.Struct
+}
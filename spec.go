@@ -0,0 +1,126 @@
+package nmfmt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// verbSpec is a parsed verb like `+08.2f` or `*width.*prec f`.
+type verbSpec struct {
+	flags string
+	group bool // `,` flag
+
+	width    int
+	widthArg string // name of an arg giving the width (`*name`)
+	hasWidth bool
+
+	prec    int
+	precArg string // name of an arg giving the precision (`.*name`)
+	hasPrec bool
+
+	verb byte
+}
+
+// fmtVerbs are verb characters accepted by fmt.
+const fmtVerbs = "vTtbcdoOqxXUeEfFgGspw"
+
+// String returns a fmt style verb. A width or a precision from an arg is `*`.
+func (v verbSpec) String() string {
+	var sb strings.Builder
+	sb.WriteByte('%')
+	sb.WriteString(v.flags)
+	if v.widthArg != "" {
+		sb.WriteByte('*')
+	} else if v.hasWidth {
+		sb.WriteString(strconv.Itoa(v.width))
+	}
+	if v.precArg != "" {
+		sb.WriteString(".*")
+	} else if v.hasPrec {
+		sb.WriteByte('.')
+		sb.WriteString(strconv.Itoa(v.prec))
+	}
+	sb.WriteByte(v.verb)
+	return sb.String()
+}
+
+// parseSpec parses a verb in ${name:verb}.
+//
+// Flags (-+# 0), a width, a precision and a verb character are accepted like fmt.
+// A `,` flag groups digits of a number in the locale.
+// A width or a precision can be taken from another arg by `*name` or `.*name`.
+func parseSpec(spec string) (verbSpec, error) {
+	var v verbSpec
+
+	s := &scanner{src: strings.TrimPrefix(strings.TrimSpace(spec), "%")}
+	if s.src == "" {
+		return v, errorString("empty verb")
+	}
+
+	start := s.pos
+	for c := s.peek(); c != 0 && strings.IndexByte("-+# 0,", c) != -1; c = s.peek() {
+		s.pos++
+	}
+	v.flags = s.src[start:s.pos]
+	if strings.IndexByte(v.flags, ',') != -1 {
+		v.group = true
+		v.flags = strings.ReplaceAll(v.flags, ",", "")
+	}
+
+	if s.peek() == '*' {
+		s.pos++
+		if v.widthArg = s.word(); v.widthArg == "" {
+			return v, errorString("empty width name")
+		}
+		s.skipSpaces()
+	} else if num := s.digits(); num != "" {
+		v.width, _ = strconv.Atoi(num)
+		v.hasWidth = true
+	}
+
+	if s.peek() == '.' {
+		s.pos++
+		v.hasPrec = true
+
+		if s.peek() == '*' {
+			s.pos++
+			if v.precArg = s.word(); v.precArg == "" {
+				return v, errorString("empty precision name")
+			}
+			s.skipSpaces()
+		} else if num := s.digits(); num != "" {
+			v.prec, _ = strconv.Atoi(num)
+		} else {
+			// `.prec f` (a name followed by spaces) is also a precision from an arg
+			start := s.pos
+			if name := s.word(); name != "" && (s.peek() == ' ' || s.peek() == '\t') {
+				v.precArg = name
+				s.skipSpaces()
+			} else {
+				s.pos = start
+			}
+		}
+	}
+
+	c := s.peek()
+	if c == 0 || strings.IndexByte(fmtVerbs, c) == -1 {
+		return v, errorString("invalid verb " + strconv.Quote(spec))
+	}
+	v.verb = c
+	s.pos++
+
+	if s.pos != len(s.src) {
+		return v, errorString("invalid verb " + strconv.Quote(spec))
+	}
+
+	return v, nil
+}
+
+// digits reads [0-9]+.
+func (s *scanner) digits() string {
+	start := s.pos
+	for '0' <= s.peek() && s.peek() <= '9' {
+		s.pos++
+	}
+	return s.src[start:s.pos]
+}
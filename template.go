@@ -0,0 +1,79 @@
+package nmfmt
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Template is a compiled format.
+//
+// A Template is safe for concurrent use, and skips the cache lookup of Formatter.
+// It follows the options (MissingKey, StrictArgs, ...) of the Formatter it is compiled by.
+type Template struct {
+	f  *Formatter
+	cn *cachenode
+}
+
+// SyntaxError describes a malformed placeholder in a format.
+type SyntaxError struct {
+	Format string
+	Offset int // byte offset of the placeholder in Format
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("nmfmt: %s at offset %d in %q", e.Msg, e.Offset, e.Format)
+}
+
+// Compile parses a format and returns a Template bound to the default Formatter.
+func Compile(format string) (*Template, error) {
+	return f.Compile(format)
+}
+
+// MustCompile is like Compile but panics if the format cannot be parsed.
+func MustCompile(format string) *Template {
+	t, err := Compile(format)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Compile parses a format and returns a Template bound to f.
+func (f *Formatter) Compile(format string) (*Template, error) {
+	cn, err := newCacheNode(format, f.cache.filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Template{f: f, cn: cn}, nil
+}
+
+// String returns the format t was compiled from.
+func (t *Template) String() string {
+	return t.cn.source
+}
+
+func (t *Template) Printf(a ...any) (int, error) {
+	return t.f.fprintf(os.Stdout, t.cn, a)
+}
+
+func (t *Template) Fprintf(w io.Writer, a ...any) (int, error) {
+	return t.f.fprintf(w, t.cn, a)
+}
+
+func (t *Template) Sprintf(a ...any) string {
+	return t.f.sprintf(t.cn, a)
+}
+
+func (t *Template) Errorf(a ...any) error {
+	return t.f.errorf(t.cn, a)
+}
+
+// Appendf formats, appends the result to b and returns it.
+//
+// On an error of formatting, b is returned as it is. See [Formatter.Appendf].
+func (t *Template) Appendf(b []byte, a ...any) ([]byte, error) {
+	return t.f.appendf(b, t.cn, a)
+}